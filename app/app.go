@@ -1,12 +1,23 @@
 package app
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
@@ -16,6 +27,13 @@ import (
 )
 
 var LDAPSessions map[string]*LDAPClient
+var ldapSessionsLock sync.RWMutex // guards LDAPSessions which is used by concurrent requests
+var MembershipExpiries *MembershipExpiryStore
+var DeletedEntries *DeletedEntryStore
+var AuditLog *AuditLogger
+var Webhooks *WebhookQueue
+var PersistedSessions *SessionStore
+var ActiveConfig *ConfigReloader
 var AppVersion = "1.0.6"
 var APIVersion = "1.0.4"
 
@@ -23,42 +41,347 @@ func Run() {
 	gob.Register(LDAPClient{})
 	gin.SetMode(gin.ReleaseMode)
 
-	log.Printf("Starting ProxmoxAAS-LDAP version %s\n", APIVersion)
-
-	configPath := flag.String("config", "config.json", "path to config.json file")
+	configPath := flag.String("config", "config.json", "path to config file, the format is selected by the extension .json, .yaml, .yml, or .toml")
+	checkConfig := flag.Bool("check-config", false, "validate the config file and environment overrides and exit")
 	flag.Parse()
 
 	config, err := GetConfig(*configPath)
+	if err == nil {
+		err = config.Validate()
+	}
+	if *checkConfig {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s is invalid:\n%s\n", *configPath, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s is valid\n", *configPath)
+		os.Exit(0)
+	}
 	if err != nil {
-		log.Fatalf("Error when reading config file: %s\n", err)
+		fatal("Error when reading config file", "path", *configPath, "error", err)
 	}
-	log.Printf("Read in config from %s\n", *configPath)
-
-	secretKey := make([]byte, 256)
-	n, err := rand.Read(secretKey)
+	logger, err := NewLogger(os.Stderr, config.Log.Level, config.Log.Format)
 	if err != nil {
-		log.Fatalf("Error when generating session secret key: %s\n", err.Error())
+		fatal("Error when configuring logger", "error", err)
+	}
+	slog.SetDefault(logger)
+
+	slog.Info("Starting ProxmoxAAS-LDAP", "version", APIVersion)
+	slog.Info("Read in config", "path", *configPath)
+
+	var secretKey []byte
+	if config.SessionStore.Path != "" { // persisted sessions need the same cookie secret key after a restart
+		secretKeyPath := config.SessionStore.SecretKeyPath
+		if secretKeyPath == "" {
+			secretKeyPath = config.SessionStore.Path + ".key"
+		}
+		secretKey, err = LoadSecretKey(secretKeyPath)
+		if err != nil {
+			fatal("Error when loading session secret key", "error", err)
+		}
+		slog.Info("Loaded session secret key", "path", secretKeyPath)
+	} else {
+		secretKey = make([]byte, 256)
+		n, err := rand.Read(secretKey)
+		if err != nil {
+			fatal("Error when generating session secret key", "error", err)
+		}
+		slog.Info("Generated session secret key", "length", n)
 	}
-	log.Printf("Generated session secret key of length %d\n", n)
 
-	router := gin.Default()
+	router := gin.New()
+	router.Use(RequestIDMiddleware(), LoggerMiddleware(), RecoveryMiddleware())
+
+	tracerProvider, err := StartTracing(context.Background(), config)
+	if err != nil {
+		fatal("Error when starting tracing", "error", err)
+	}
+	if tracerProvider != nil {
+		router.Use(TracingMiddleware(tracerProvider))
+		slog.Info("Started tracing", "exporter", config.Tracing.Exporter, "endpoint", config.Tracing.Endpoint)
+	}
 	store := cookie.NewStore(secretKey)
 	store.Options(sessions.Options{
 		Path:     config.SessionCookie.Path,
 		HttpOnly: config.SessionCookie.HttpOnly,
 		Secure:   config.SessionCookie.Secure,
 		MaxAge:   config.SessionCookie.MaxAge,
+		SameSite: sameSiteModes[config.SessionCookie.SameSite],
 	})
+	if config.Metrics {
+		router.Use(MetricsMiddleware())
+	}
+	router.Use(CORSMiddleware(config))
 	router.Use(sessions.Sessions(config.SessionCookieName, store))
+	if config.TLS.ClientAuth.CAFile != "" {
+		router.Use(ClientCertMiddleware(config))
+		slog.Info("Started client certificate authentication", "caFile", config.TLS.ClientAuth.CAFile, "required", config.TLS.ClientAuth.Required, "mappings", len(config.TLS.ClientAuth.Mappings))
+	}
+	router.Use(CSRFMiddleware(config)) // after client certificates so their sessions are checked too
 
-	log.Printf("Started API router and cookie store (Name: %s Params: %+v)\n", config.SessionCookieName, config.SessionCookie)
+	slog.Info("Started API router and cookie store", "name", config.SessionCookieName, "path", config.SessionCookie.Path, "httpOnly", config.SessionCookie.HttpOnly, "secure", config.SessionCookie.Secure, "maxAge", config.SessionCookie.MaxAge)
 
 	LDAPSessions = make(map[string]*LDAPClient)
 
+	if config.SessionStore.Path != "" {
+		PersistedSessions, err = NewSessionStore(config.SessionStore.Path)
+		if err != nil {
+			fatal("Error when reading sessions", "error", err)
+		}
+		restored := RestoreSessions(config, PersistedSessions)
+		slog.Info("Restored sessions", "path", config.SessionStore.Path, "count", restored)
+	}
+
+	if config.MembershipExpiry.Path != "" {
+		MembershipExpiries, err = NewMembershipExpiryStore(config.MembershipExpiry.Path)
+		if err != nil {
+			fatal("Error when reading membership expiries", "error", err)
+		}
+		go RunMembershipExpiry(config, MembershipExpiries)
+		slog.Info("Started membership expiry job", "path", config.MembershipExpiry.Path)
+	}
+
+	if config.SoftDelete.Path != "" {
+		retention := time.Duration(config.SoftDelete.RetentionDays) * 24 * time.Hour
+		DeletedEntries, err = NewDeletedEntryStore(config.SoftDelete.Path, retention)
+		if err != nil {
+			fatal("Error when reading deleted entries", "error", err)
+		}
+		go RunPurgeDeletedEntries(config, DeletedEntries)
+		slog.Info("Started soft delete purge job", "path", config.SoftDelete.Path, "retentionDays", config.SoftDelete.RetentionDays)
+	}
+
+	if config.Audit.Path != "" || config.Audit.Syslog {
+		AuditLog, err = NewAuditLogger(config.Audit.Path, config.Audit.Syslog)
+		if err != nil {
+			fatal("Error when opening audit log", "error", err)
+		}
+		slog.Info("Started audit log", "path", config.Audit.Path, "syslog", config.Audit.Syslog)
+	}
+
+	if config.Webhooks.Path != "" {
+		Webhooks, err = NewWebhookQueue(
+			config.Webhooks.Path,
+			config.Webhooks.Subscribers,
+			config.Webhooks.MaxAttempts,
+			time.Duration(config.Webhooks.Backoff)*time.Second,
+			time.Duration(config.Webhooks.RetentionDays)*24*time.Hour,
+		)
+		if err != nil {
+			fatal("Error when reading webhook queue", "error", err)
+		}
+		go RunWebhookDeliveries(config, Webhooks)
+		slog.Info("Started webhook delivery job", "path", config.Webhooks.Path, "subscribers", len(config.Webhooks.Subscribers))
+	}
+
+	switch config.AccountExpiry.Policy {
+	case "":
+	case "lock", "delete":
+		if config.AccountExpiry.Attribute == "" {
+			fatal("Error when starting account expiry job: accountExpiry.attribute is required", "policy", config.AccountExpiry.Policy)
+		}
+		go RunAccountExpiry(config)
+		slog.Info("Started account expiry job", "policy", config.AccountExpiry.Policy)
+	default:
+		fatal("Error when starting account expiry job: unknown policy", "policy", config.AccountExpiry.Policy)
+	}
+
+	if config.ValidateRequests || config.ValidateResponses {
+		document, err := LoadOpenAPI()
+		if err != nil {
+			fatal("Error when loading OpenAPI document", "error", err)
+		}
+		router.Use(OpenAPIMiddleware(document, config.ValidateRequests, config.ValidateResponses))
+		slog.Info("Started OpenAPI validation", "requests", config.ValidateRequests, "responses", config.ValidateResponses)
+	}
+
+	ActiveConfig = NewConfigReloader(*configPath, config)
+	go ActiveConfig.Watch(time.Duration(config.ReloadInterval) * time.Second)
+	slog.Info("Started config reload on SIGHUP", "path", *configPath, "reloadInterval", config.ReloadInterval)
+
+	RegisterRoutes(router, config)
+
+	bindAddress := config.BindAddress
+	if bindAddress == "" {
+		bindAddress = "0.0.0.0"
+	}
+	server := NewServer(net.JoinHostPort(bindAddress, strconv.Itoa(config.ListenPort)), router.Handler())
+	server.Protocols = NewProtocols(config)
+	var redirectServer *http.Server
+	if config.TLS.CertFile != "" {
+		certificates, err := NewCertificateReloader(config.TLS.CertFile, config.TLS.KeyFile)
+		if err != nil {
+			fatal("Error when loading TLS certificate", "error", err)
+		}
+		interval := time.Duration(config.TLS.ReloadInterval) * time.Second
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		go certificates.Watch(interval)
+		server.TLSConfig, err = NewTLSConfig(config, certificates)
+		if err != nil {
+			fatal("Error when configuring TLS", "error", err)
+		}
+	}
+
+	listeners, err := SystemdListeners()
+	if err != nil {
+		fatal("Error when using systemd sockets", "error", err)
+	}
+	if len(listeners) == 0 { // not socket activated
+		listener, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			fatal("Error starting router", "error", err)
+		}
+		listeners = append(listeners, listener)
+	} else {
+		slog.Info("Using systemd socket activation", "sockets", len(listeners))
+	}
+	if config.UnixSocket.Path != "" {
+		mode, _ := unixSocketMode(config.UnixSocket.Mode)
+		listener, err := ListenUnix(config.UnixSocket.Path, mode, config.UnixSocket.Group)
+		if err != nil {
+			fatal("Error when listening on unix socket", "path", config.UnixSocket.Path, "error", err)
+		}
+		listeners = append(listeners, listener)
+	}
+	if server.TLSConfig != nil && config.TLS.RedirectPort != 0 { // redirect to the port actually served over HTTPS which differs from listenPort when socket activated
+		redirectServer = NewServer(net.JoinHostPort(bindAddress, strconv.Itoa(config.TLS.RedirectPort)), RedirectHandler(TCPListenerPort(listeners, config.ListenPort)))
+	}
+
+	for _, listener := range listeners {
+		useTLS := server.TLSConfig != nil && listener.Addr().Network() != "unix" // unix sockets are local and served over plain HTTP
+		slog.Info("Starting LDAP API", "network", listener.Addr().Network(), "address", listener.Addr().String(), "tls", useTLS, "http2", !config.TLS.DisableHTTP2)
+		go func() {
+			var err error
+			if useTLS {
+				err = server.ServeTLS(listener, "", "")
+			} else {
+				err = server.Serve(listener)
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal("Error starting router", "error", err)
+			}
+		}()
+	}
+	if redirectServer != nil {
+		slog.Info("Starting HTTP to HTTPS redirect", "address", redirectServer.Addr)
+		go func() {
+			err := redirectServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal("Error starting redirect listener", "error", err)
+			}
+		}()
+	}
+
+	if _, err := SdNotify("READY=1"); err != nil {
+		slog.Error("Error when notifying systemd", "error", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if timeout := WatchdogInterval(); timeout > 0 {
+		go RunWatchdog(ctx, timeout, HandlerHealthCheck(router.Handler()))
+		slog.Info("Started systemd watchdog", "timeout", timeout)
+	}
+	<-ctx.Done()
+	stop()
+	SdNotify("STOPPING=1")
+
+	timeout := time.Duration(currentConfig(config).ShutdownTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	slog.Info("Shutting down LDAP API", "timeout", timeout)
+	if redirectServer != nil {
+		redirectServer.Close()
+	}
+	err = ShutdownServer(server, timeout)
+	if err != nil {
+		slog.Warn("Error when draining requests", "error", err)
+	}
+	if tracerProvider != nil { // export the spans still queued, including those of the drained requests
+		flushCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := tracerProvider.Shutdown(flushCtx); err != nil {
+			slog.Error("Error when exporting spans", "error", err)
+		}
+		cancel()
+	}
+	slog.Info("Stopped LDAP API")
+}
+
+// returns the session of the uuid, or nil if there is none or it has expired
+func GetSession(uuid string) *LDAPClient {
+	ldapSessionsLock.RLock()
+	client := LDAPSessions[uuid]
+	ldapSessionsLock.RUnlock()
+	if client == nil || client.expiresAt.IsZero() || time.Now().Before(client.expiresAt) {
+		return client
+	}
+
+	// persisted sessions end at their expiry even if the ticket cookie does not
+	ldapSessionsLock.Lock()
+	removed := LDAPSessions[uuid] == client
+	if removed {
+		delete(LDAPSessions, uuid)
+	}
+	ldapSessionsLock.Unlock()
+	if removed {
+		client.Close()
+		if PersistedSessions != nil {
+			if err := PersistedSessions.Delete(uuid); err != nil {
+				slog.Error("Error when deleting session", "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+func SetSession(uuid string, client *LDAPClient) {
+	ldapSessionsLock.Lock()
+	defer ldapSessionsLock.Unlock()
+	LDAPSessions[uuid] = client
+}
+
+// replace the session of the uuid if it is still the old session or was removed, returns the session which is registered afterwards
+func SwapSession(uuid string, old *LDAPClient, client *LDAPClient) *LDAPClient {
+	ldapSessionsLock.Lock()
+	defer ldapSessionsLock.Unlock()
+	if current := LDAPSessions[uuid]; current != old && current != nil { // another request replaced it first
+		return current
+	}
+	LDAPSessions[uuid] = client
+	return client
+}
+
+// unregister the session of the uuid and return it, or nil if there is none
+func RemoveSession(uuid string) *LDAPClient {
+	ldapSessionsLock.Lock()
+	defer ldapSessionsLock.Unlock()
+	client := LDAPSessions[uuid]
+	delete(LDAPSessions, uuid)
+	return client
+}
+
+// returns the number of sessions
+func SessionCount() int {
+	ldapSessionsLock.RLock()
+	defer ldapSessionsLock.RUnlock()
+	return len(LDAPSessions)
+}
+
+// register all API routes, every route must be described in openapi.json
+func RegisterRoutes(router *gin.Engine, config Config) {
 	router.GET("/version", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"version": APIVersion, "app-version": AppVersion})
 	})
 
+	router.GET("/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", openAPIDocument)
+	})
+
+	if config.Metrics {
+		router.GET("/metrics", gin.WrapH(MetricsHandler()))
+	}
+
 	router.POST("/ticket", func(c *gin.Context) {
 		var body Login
 		if err := c.ShouldBind(&body); err != nil { // bad request from binding
@@ -66,16 +389,31 @@ func Run() {
 			return
 		}
 
-		newLDAPClient, err := NewLDAPClient(config)
+		config := currentConfig(config) // new sessions use the reloaded ldap settings
+		newLDAPClient, err := NewLDAPClientWithContext(c.Request.Context(), config)
 		if err != nil { // failed to dial ldap server, considered a server error
 			c.JSON(http.StatusInternalServerError, gin.H{"auth": false, "error": err.Error()})
 			return
 		}
 		err = newLDAPClient.BindUser(body.Username, body.Password)
 		if err != nil { // failed to authenticate, return error
+			newLDAPClient.client.Close()
+			Logins.WithLabelValues("failure").Inc()
 			c.JSON(http.StatusBadRequest, gin.H{"auth": false, "error": err.Error()})
 			return
 		}
+		expired, err := newLDAPClient.IsUserExpired(body.Username, time.Now())
+		if err != nil { // failed to read the account expiry, considered a server error
+			newLDAPClient.client.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"auth": false, "error": err.Error()})
+			return
+		}
+		if expired { // expired accounts cannot log in even if the account has not been locked yet
+			newLDAPClient.client.Close()
+			Logins.WithLabelValues("failure").Inc()
+			c.JSON(http.StatusBadRequest, gin.H{"auth": false, "error": "account has expired"})
+			return
+		}
 
 		// successful binding at this point
 		// create new session
@@ -84,12 +422,33 @@ func Run() {
 		uuid, _ := uuid.NewV4()
 		// set uuid mapping in session
 		session.Set("SessionUUID", uuid.String())
-		// set uuid mapping in LDAPSessions
-		LDAPSessions[uuid.String()] = newLDAPClient
-		// save the session
+		// issue a new csrf token which must be sent with state changing requests
+		csrfToken := NewCSRFToken()
+		session.Set("CSRFToken", csrfToken)
+		// set uuid mapping in LDAPSessions, later requests trace with their own context
+		newLDAPClient.ctx = nil
+		if PersistedSessions != nil { // persisted sessions have the same expiry before and after a restart
+			newLDAPClient.expiresAt = time.Now().Add(config.PersistedSessionMaxAge())
+		}
+		SetSession(uuid.String(), newLDAPClient)
+		if PersistedSessions != nil {
+			if err := PersistedSessions.Add(uuid.String(), body.Username, newLDAPClient.expiresAt); err != nil {
+				slog.Error("Error when persisting session", RequestIDKey, c.GetString(RequestIDKey), "error", err)
+			}
+		}
+		// save the session with the reloaded cookie options
+		session.Options(sessions.Options{
+			Path:     config.SessionCookie.Path,
+			HttpOnly: config.SessionCookie.HttpOnly,
+			Secure:   config.SessionCookie.Secure,
+			MaxAge:   config.SessionCookie.MaxAge,
+			SameSite: sameSiteModes[config.SessionCookie.SameSite],
+		})
 		session.Save()
+		Logins.WithLabelValues("success").Inc()
 		// return successful auth
-		c.JSON(http.StatusOK, gin.H{"auth": true})
+		c.Header(CSRFHeader, csrfToken)
+		c.JSON(http.StatusOK, gin.H{"auth": true, "csrfToken": csrfToken})
 	})
 
 	router.DELETE("/ticket", func(c *gin.Context) {
@@ -100,7 +459,16 @@ func Run() {
 			return
 		}
 		uuid := SessionUUID.(string)
-		delete(LDAPSessions, uuid)
+		if strings.HasPrefix(uuid, clientCertSessionPrefix) { // client certificate identities have no ticket to delete
+			c.JSON(http.StatusBadRequest, gin.H{"auth": true, "error": "client certificate sessions cannot be deleted"})
+			return
+		}
+		RemoveSession(uuid).Close()
+		if PersistedSessions != nil {
+			if err := PersistedSessions.Delete(uuid); err != nil {
+				slog.Error("Error when deleting session", RequestIDKey, c.GetString(RequestIDKey), "error", err)
+			}
+		}
 		session.Options(sessions.Options{MaxAge: -1}) // set max age to -1 so it is deleted
 		session.Save()
 		c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
@@ -114,13 +482,18 @@ func Run() {
 			return
 		}
 		uuid := SessionUUID.(string)
-		LDAPSession := LDAPSessions[uuid]
+		LDAPSession := GetSession(uuid).WithRequest(c)
 		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
 			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
 			return
 		}
 
 		status, res := LDAPSession.GetAllUsers()
+		if status == http.StatusOK && MembershipExpiries != nil {
+			for _, user := range res["users"].([]gin.H) {
+				MembershipExpiries.AnnotateUser(user)
+			}
+		}
 		c.JSON(status, HandleResponse(res))
 	})
 
@@ -132,7 +505,7 @@ func Run() {
 			return
 		}
 		uuid := SessionUUID.(string)
-		LDAPSession := LDAPSessions[uuid]
+		LDAPSession := GetSession(uuid).WithRequest(c)
 		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
 			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
 			return
@@ -147,6 +520,8 @@ func Run() {
 				return
 			}
 			status, res = LDAPSession.AddUser(c.Param("userid"), body)
+			Audit(c, LDAPSession, "user.create", fmt.Sprintf("uid=%s,%s", c.Param("userid"), LDAPSession.peopledn), userAttributeNames(body.CN, body.SN, body.Mail, body.UserPassword, body.ExpiresAt), status, res)
+			PublishEvent(LDAPSession, "user.created", fmt.Sprintf("uid=%s,%s", c.Param("userid"), LDAPSession.peopledn), "", status)
 			c.JSON(status, HandleResponse(res))
 		} else { // user already exists, attempt to modify user
 			var body UserOptional                       // all user attributes optional for new users
@@ -155,6 +530,8 @@ func Run() {
 				return
 			}
 			status, res = LDAPSession.ModUser(c.Param("userid"), body)
+			Audit(c, LDAPSession, "user.modify", fmt.Sprintf("uid=%s,%s", c.Param("userid"), LDAPSession.peopledn), userAttributeNames(body.CN, body.SN, body.Mail, body.UserPassword, body.ExpiresAt), status, res)
+			PublishEvent(LDAPSession, "user.updated", fmt.Sprintf("uid=%s,%s", c.Param("userid"), LDAPSession.peopledn), "", status)
 			c.JSON(status, HandleResponse(res))
 		}
 	})
@@ -167,13 +544,16 @@ func Run() {
 			return
 		}
 		uuid := SessionUUID.(string)
-		LDAPSession := LDAPSessions[uuid]
+		LDAPSession := GetSession(uuid).WithRequest(c)
 		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
 			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
 			return
 		}
 
 		status, res := LDAPSession.GetUser(c.Param("userid"))
+		if status == http.StatusOK && MembershipExpiries != nil {
+			MembershipExpiries.AnnotateUser(res["user"].(gin.H))
+		}
 		c.JSON(status, HandleResponse(res))
 	})
 
@@ -185,13 +565,142 @@ func Run() {
 			return
 		}
 		uuid := SessionUUID.(string)
-		LDAPSession := LDAPSessions[uuid]
+		LDAPSession := GetSession(uuid).WithRequest(c)
 		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
 			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
 			return
 		}
 
-		status, res := LDAPSession.DelUser(c.Param("userid"))
+		status, res := LDAPSession.deleteUser(c.Param("userid"))
+		Audit(c, LDAPSession, "user.delete", fmt.Sprintf("uid=%s,%s", c.Param("userid"), LDAPSession.peopledn), nil, status, res)
+		PublishEvent(LDAPSession, "user.deleted", fmt.Sprintf("uid=%s,%s", c.Param("userid"), LDAPSession.peopledn), "", status)
+		c.JSON(status, HandleResponse(res))
+	})
+
+	router.GET("/users/:userid/groups", func(c *gin.Context) {
+		session := sessions.Default(c)
+		SessionUUID := session.Get("SessionUUID")
+		if SessionUUID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		uuid := SessionUUID.(string)
+		LDAPSession := GetSession(uuid).WithRequest(c)
+		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+
+		status, res := LDAPSession.GetUserGroups(c.Param("userid"))
+		c.JSON(status, HandleResponse(res))
+	})
+
+	router.PUT("/users/:userid/groups", func(c *gin.Context) {
+		session := sessions.Default(c)
+		SessionUUID := session.Get("SessionUUID")
+		if SessionUUID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		uuid := SessionUUID.(string)
+		LDAPSession := GetSession(uuid).WithRequest(c)
+		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+
+		var body UserGroups
+		if err := c.ShouldBind(&body); err != nil { // bad request from binding
+			c.JSON(http.StatusBadRequest, gin.H{"auth": false, "error": err.Error()})
+			return
+		}
+
+		status, res := LDAPSession.SetUserGroups(c.Param("userid"), body.Groups)
+		if status == http.StatusOK || status == http.StatusMultiStatus { // memberships changed without an expiry are no longer temporary
+			userDN := fmt.Sprintf("uid=%s,%s", c.Param("userid"), LDAPSession.peopledn)
+			for _, gid := range append(res["added"].([]string), res["removed"].([]string)...) {
+				clearMembershipExpiry(fmt.Sprintf("cn=%s,%s", gid, LDAPSession.groupsdn), userDN)
+			}
+		}
+		Audit(c, LDAPSession, "user.groups_set", fmt.Sprintf("uid=%s,%s", c.Param("userid"), LDAPSession.peopledn), []string{"memberOf"}, status, res)
+		PublishEvent(LDAPSession, "user.groups_updated", fmt.Sprintf("uid=%s,%s", c.Param("userid"), LDAPSession.peopledn), "", status)
+		if status == http.StatusOK || status == http.StatusMultiStatus { // each changed membership is audited and published like a single membership change
+			userDN := fmt.Sprintf("uid=%s,%s", c.Param("userid"), LDAPSession.peopledn)
+			for _, gid := range res["added"].([]string) {
+				AuditMember(c, LDAPSession, "group.member_added", fmt.Sprintf("cn=%s,%s", gid, LDAPSession.groupsdn), userDN, http.StatusOK, nil)
+				PublishEvent(LDAPSession, "group.member_added", fmt.Sprintf("cn=%s,%s", gid, LDAPSession.groupsdn), userDN, status)
+			}
+			for _, gid := range res["removed"].([]string) {
+				AuditMember(c, LDAPSession, "group.member_removed", fmt.Sprintf("cn=%s,%s", gid, LDAPSession.groupsdn), userDN, http.StatusOK, nil)
+				PublishEvent(LDAPSession, "group.member_removed", fmt.Sprintf("cn=%s,%s", gid, LDAPSession.groupsdn), userDN, status)
+			}
+		}
+		c.JSON(status, HandleResponse(res))
+	})
+
+	router.GET("/users/:userid/effective-groups", func(c *gin.Context) {
+		session := sessions.Default(c)
+		SessionUUID := session.Get("SessionUUID")
+		if SessionUUID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		uuid := SessionUUID.(string)
+		LDAPSession := GetSession(uuid).WithRequest(c)
+		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+
+		status, res := LDAPSession.GetUserEffectiveGroups(c.Param("userid"))
+		c.JSON(status, HandleResponse(res))
+	})
+
+	router.POST("/users/:userid/restore", func(c *gin.Context) {
+		session := sessions.Default(c)
+		SessionUUID := session.Get("SessionUUID")
+		if SessionUUID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		uuid := SessionUUID.(string)
+		LDAPSession := GetSession(uuid).WithRequest(c)
+		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		if DeletedEntries == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"auth": true, "error": "soft delete is not enabled"})
+			return
+		}
+
+		status, res := LDAPSession.RestoreUser(c.Param("userid"), DeletedEntries)
+		Audit(c, LDAPSession, "user.restore", fmt.Sprintf("uid=%s,%s", c.Param("userid"), LDAPSession.peopledn), nil, status, res)
+		PublishEvent(LDAPSession, "user.restored", fmt.Sprintf("uid=%s,%s", c.Param("userid"), LDAPSession.peopledn), "", status)
+		c.JSON(status, HandleResponse(res))
+	})
+
+	router.GET("/reports/expiring-users", func(c *gin.Context) {
+		session := sessions.Default(c)
+		SessionUUID := session.Get("SessionUUID")
+		if SessionUUID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		uuid := SessionUUID.(string)
+		LDAPSession := GetSession(uuid).WithRequest(c)
+		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+
+		days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+		if err != nil || days < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"auth": true, "error": "days must be a non-negative integer"})
+			return
+		}
+
+		status, res := LDAPSession.GetExpiringUsers(days)
 		c.JSON(status, HandleResponse(res))
 	})
 
@@ -203,13 +712,18 @@ func Run() {
 			return
 		}
 		uuid := SessionUUID.(string)
-		LDAPSession := LDAPSessions[uuid]
+		LDAPSession := GetSession(uuid).WithRequest(c)
 		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
 			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
 			return
 		}
 
 		status, res := LDAPSession.GetAllGroups()
+		if status == http.StatusOK && MembershipExpiries != nil {
+			for _, group := range res["groups"].([]gin.H) {
+				MembershipExpiries.AnnotateGroup(group)
+			}
+		}
 		c.JSON(status, HandleResponse(res))
 	})
 
@@ -221,13 +735,16 @@ func Run() {
 			return
 		}
 		uuid := SessionUUID.(string)
-		LDAPSession := LDAPSessions[uuid]
+		LDAPSession := GetSession(uuid).WithRequest(c)
 		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
 			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
 			return
 		}
 
 		status, res := LDAPSession.GetGroup(c.Param("groupid"))
+		if status == http.StatusOK && MembershipExpiries != nil {
+			MembershipExpiries.AnnotateGroup(res["group"].(gin.H))
+		}
 		c.JSON(status, HandleResponse(res))
 	})
 
@@ -239,7 +756,7 @@ func Run() {
 			return
 		}
 		uuid := SessionUUID.(string)
-		LDAPSession := LDAPSessions[uuid]
+		LDAPSession := GetSession(uuid).WithRequest(c)
 		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
 			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
 			return
@@ -250,14 +767,36 @@ func Run() {
 			c.JSON(http.StatusBadRequest, gin.H{"auth": false, "error": err.Error()})
 			return
 		}
+		body.Owner = nonEmptyValues(body.Owner)
+		body.BusinessCategory = nonEmptyValues(body.BusinessCategory)
+		body.Attributes = make(map[string][]string)
+		body.Clear = []string{}
+		for _, attr := range clearableGroupAttributes { // fields which are present but empty clear the attribute while absent fields are unchanged
+			if values, ok := c.GetPostFormArray(attr); ok && len(nonEmptyValues(values)) == 0 {
+				body.Clear = append(body.Clear, attr)
+			}
+		}
+		for _, attr := range currentConfig(config).GroupAttributes { // bind any extra attributes allowed by the config
+			if values, ok := c.GetPostFormArray(attr); ok {
+				if values = nonEmptyValues(values); len(values) > 0 {
+					body.Attributes[attr] = values
+				} else {
+					body.Clear = append(body.Clear, attr)
+				}
+			}
+		}
 
 		// check if group already exists
 		status, res := LDAPSession.GetGroup(c.Param("groupid"))
 		if status != 200 && ldap.IsErrorWithCode(res["error"].(error), ldap.LDAPResultNoSuchObject) { // group does not already exist, create new group
 			status, res = LDAPSession.AddGroup(c.Param("groupid"), body)
+			Audit(c, LDAPSession, "group.create", fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn), groupAttributeNames(body), status, res)
+			PublishEvent(LDAPSession, "group.created", fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn), "", status)
 			c.JSON(status, HandleResponse(res))
 		} else { // group already exists, attempt to modify group
 			status, res = LDAPSession.ModGroup(c.Param("groupid"), body)
+			Audit(c, LDAPSession, "group.modify", fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn), groupAttributeNames(body), status, res)
+			PublishEvent(LDAPSession, "group.updated", fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn), "", status)
 			c.JSON(status, HandleResponse(res))
 		}
 	})
@@ -270,13 +809,275 @@ func Run() {
 			return
 		}
 		uuid := SessionUUID.(string)
-		LDAPSession := LDAPSessions[uuid]
+		LDAPSession := GetSession(uuid).WithRequest(c)
 		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
 			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
 			return
 		}
 
-		status, res := LDAPSession.DelGroup(c.Param("groupid"))
+		var status int
+		var res gin.H
+		if DeletedEntries != nil { // soft delete moves the group to the trash so that it can be restored
+			status, res = LDAPSession.SoftDelGroup(c.Param("groupid"), DeletedEntries)
+		} else {
+			status, res = LDAPSession.DelGroup(c.Param("groupid"))
+		}
+		if status == http.StatusOK {
+			clearEntryExpiries(fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn))
+		}
+		Audit(c, LDAPSession, "group.delete", fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn), nil, status, res)
+		PublishEvent(LDAPSession, "group.deleted", fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn), "", status)
+		c.JSON(status, HandleResponse(res))
+	})
+
+	router.POST("/groups/:groupid/restore", func(c *gin.Context) {
+		session := sessions.Default(c)
+		SessionUUID := session.Get("SessionUUID")
+		if SessionUUID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		uuid := SessionUUID.(string)
+		LDAPSession := GetSession(uuid).WithRequest(c)
+		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		if DeletedEntries == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"auth": true, "error": "soft delete is not enabled"})
+			return
+		}
+
+		status, res := LDAPSession.RestoreGroup(c.Param("groupid"), DeletedEntries)
+		Audit(c, LDAPSession, "group.restore", fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn), nil, status, res)
+		PublishEvent(LDAPSession, "group.restored", fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn), "", status)
+		c.JSON(status, HandleResponse(res))
+	})
+
+	router.GET("/trash", func(c *gin.Context) {
+		session := sessions.Default(c)
+		SessionUUID := session.Get("SessionUUID")
+		if SessionUUID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		uuid := SessionUUID.(string)
+		LDAPSession := GetSession(uuid).WithRequest(c)
+		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		if DeletedEntries == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"auth": true, "error": "soft delete is not enabled"})
+			return
+		}
+
+		status, res := LDAPSession.GetDeletedEntries(DeletedEntries)
+		c.JSON(status, HandleResponse(res))
+	})
+
+	router.GET("/audit", func(c *gin.Context) {
+		session := sessions.Default(c)
+		SessionUUID := session.Get("SessionUUID")
+		if SessionUUID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		uuid := SessionUUID.(string)
+		LDAPSession := GetSession(uuid).WithRequest(c)
+		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		if AuditLog == nil || currentConfig(config).Audit.Path == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"auth": true, "error": "audit log file is not enabled"})
+			return
+		}
+
+		var query AuditQuery
+		if err := c.ShouldBindQuery(&query); err != nil { // bad request from binding
+			c.JSON(http.StatusBadRequest, gin.H{"auth": true, "error": err.Error()})
+			return
+		}
+
+		status, res := LDAPSession.GetAuditEvents(AuditLog, currentConfig(config).AdminGroup, query)
+		c.JSON(status, HandleResponse(res))
+	})
+
+	router.GET("/events", func(c *gin.Context) {
+		session := sessions.Default(c)
+		SessionUUID := session.Get("SessionUUID")
+		if SessionUUID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		uuid := SessionUUID.(string)
+		LDAPSession := GetSession(uuid).WithRequest(c)
+		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+
+		id := c.GetHeader("Last-Event-ID") // resume from the cookie of the last received event
+		if id == "" {
+			id = c.Query("cookie")
+		}
+		cookie, err := DecodeSyncCookie(id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"auth": true, "error": "invalid sync cookie"})
+			return
+		}
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		var lock sync.Mutex // the keepalive and the sync both write to the stream
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			ticker := time.NewTicker(30 * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					lock.Lock()
+					_, _ = c.Writer.WriteString(": keepalive\n\n")
+					c.Writer.Flush()
+					lock.Unlock()
+				}
+			}
+		}()
+
+		err = LDAPSession.SyncEvents(c.Request.Context(), cookie, c.Query("initial") == "true", func(event DirectoryEvent) error {
+			lock.Lock()
+			defer lock.Unlock()
+			if err := WriteSSE(c.Writer, event); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+			return nil
+		})
+		if err != nil && c.Request.Context().Err() == nil { // report sync errors such as an unsupported sync control to the client
+			lock.Lock()
+			defer lock.Unlock()
+			data, _ := json.Marshal(gin.H{"error": err.Error()})
+			_, _ = fmt.Fprintf(c.Writer, "event: error\ndata: %s\n\n", data)
+			c.Writer.Flush()
+		}
+	})
+
+	router.GET("/webhooks/deliveries", func(c *gin.Context) {
+		session := sessions.Default(c)
+		SessionUUID := session.Get("SessionUUID")
+		if SessionUUID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		uuid := SessionUUID.(string)
+		LDAPSession := GetSession(uuid).WithRequest(c)
+		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		if Webhooks == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"auth": true, "error": "webhooks are not enabled"})
+			return
+		}
+
+		status, res := LDAPSession.GetWebhookDeliveries(Webhooks, currentConfig(config).AdminGroup, c.Query("status"))
+		c.JSON(status, HandleResponse(res))
+	})
+
+	router.GET("/webhooks/deliveries/:deliveryid", func(c *gin.Context) {
+		session := sessions.Default(c)
+		SessionUUID := session.Get("SessionUUID")
+		if SessionUUID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		uuid := SessionUUID.(string)
+		LDAPSession := GetSession(uuid).WithRequest(c)
+		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		if Webhooks == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"auth": true, "error": "webhooks are not enabled"})
+			return
+		}
+
+		status, res := LDAPSession.GetWebhookDelivery(Webhooks, currentConfig(config).AdminGroup, c.Param("deliveryid"))
+		c.JSON(status, HandleResponse(res))
+	})
+
+	router.GET("/config/status", func(c *gin.Context) {
+		session := sessions.Default(c)
+		SessionUUID := session.Get("SessionUUID")
+		if SessionUUID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		uuid := SessionUUID.(string)
+		LDAPSession := GetSession(uuid).WithRequest(c)
+		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		if ActiveConfig == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"auth": true, "error": "config reload is not enabled"})
+			return
+		}
+
+		status, res := LDAPSession.GetConfigStatus(ActiveConfig, currentConfig(config).AdminGroup)
+		c.JSON(status, HandleResponse(res))
+	})
+
+	router.PUT("/groups/:groupid/members", func(c *gin.Context) {
+		session := sessions.Default(c)
+		SessionUUID := session.Get("SessionUUID")
+		if SessionUUID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		uuid := SessionUUID.(string)
+		LDAPSession := GetSession(uuid).WithRequest(c)
+		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+
+		var body GroupMembers
+		if err := c.ShouldBind(&body); err != nil { // bad request from binding
+			c.JSON(http.StatusBadRequest, gin.H{"auth": false, "error": err.Error()})
+			return
+		}
+
+		status, res := LDAPSession.SetGroupMembers(c.Param("groupid"), body.Members)
+		if status == http.StatusOK { // memberships changed without an expiry are no longer temporary
+			groupDN := fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn)
+			for _, uid := range append(res["added"].([]string), res["removed"].([]string)...) {
+				clearMembershipExpiry(groupDN, fmt.Sprintf("uid=%s,%s", uid, LDAPSession.peopledn))
+			}
+		}
+		Audit(c, LDAPSession, "group.members_set", fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn), []string{"member"}, status, res)
+		PublishEvent(LDAPSession, "group.members_updated", fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn), "", status)
+		if status == http.StatusOK { // each changed membership is audited and published like a single membership change
+			groupDN := fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn)
+			for _, uid := range res["added"].([]string) {
+				AuditMember(c, LDAPSession, "group.member_added", groupDN, fmt.Sprintf("uid=%s,%s", uid, LDAPSession.peopledn), http.StatusOK, nil)
+				PublishEvent(LDAPSession, "group.member_added", groupDN, fmt.Sprintf("uid=%s,%s", uid, LDAPSession.peopledn), status)
+			}
+			for _, uid := range res["removed"].([]string) {
+				AuditMember(c, LDAPSession, "group.member_removed", groupDN, fmt.Sprintf("uid=%s,%s", uid, LDAPSession.peopledn), http.StatusOK, nil)
+				PublishEvent(LDAPSession, "group.member_removed", groupDN, fmt.Sprintf("uid=%s,%s", uid, LDAPSession.peopledn), status)
+			}
+		}
 		c.JSON(status, HandleResponse(res))
 	})
 
@@ -288,13 +1089,48 @@ func Run() {
 			return
 		}
 		uuid := SessionUUID.(string)
-		LDAPSession := LDAPSessions[uuid]
+		LDAPSession := GetSession(uuid).WithRequest(c)
 		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
 			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
 			return
 		}
 
+		var body GroupMember
+		if err := c.ShouldBind(&body); err != nil { // bad request from binding
+			c.JSON(http.StatusBadRequest, gin.H{"auth": false, "error": err.Error()})
+			return
+		}
+		if !body.ExpiresAt.IsZero() && MembershipExpiries == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"auth": true, "error": "membership expiry is not enabled"})
+			return
+		}
+		if !body.ExpiresAt.IsZero() && !body.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"auth": true, "error": "expiresAt must be in the future"})
+			return
+		}
+
+		userDN := fmt.Sprintf("uid=%s,%s", c.Param("userid"), LDAPSession.peopledn)
+		groupDN := fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn)
 		status, res := LDAPSession.AddUserToGroup(c.Param("userid"), c.Param("groupid"))
+		if status != http.StatusOK && !body.ExpiresAt.IsZero() && ldap.IsErrorWithCode(res["error"].(error), ldap.LDAPResultAttributeOrValueExists) { // only change the expiry of an existing member
+			status, res = LDAPSession.SetGroupMemberExpiry(c.Param("userid"), c.Param("groupid"), body.ExpiresAt)
+			AuditMember(c, LDAPSession, "group.member_expiry_set", groupDN, userDN, status, res)
+			PublishEvent(LDAPSession, "group.member_expiry_set", groupDN, userDN, status)
+			c.JSON(status, HandleResponse(res))
+			return
+		}
+		if status == http.StatusOK {
+			if body.ExpiresAt.IsZero() {
+				clearMembershipExpiry(groupDN, userDN)
+			} else if err := MembershipExpiries.Set(groupDN, userDN, body.ExpiresAt); err != nil { // a membership without its expiry would never be removed, so undo the add
+				if status, res := LDAPSession.DelUserFromGroup(c.Param("userid"), c.Param("groupid")); status != http.StatusOK {
+					slog.Error("Error when removing member without expiry", "group", groupDN, "member", userDN, "error", res["error"])
+				}
+				status, res = http.StatusInternalServerError, gin.H{"ok": false, "error": ldap.NewError(ldap.LDAPResultOther, err)}
+			}
+		}
+		AuditMember(c, LDAPSession, "group.member_added", groupDN, userDN, status, res)
+		PublishEvent(LDAPSession, "group.member_added", groupDN, userDN, status)
 		c.JSON(status, HandleResponse(res))
 	})
 
@@ -306,20 +1142,76 @@ func Run() {
 			return
 		}
 		uuid := SessionUUID.(string)
-		LDAPSession := LDAPSessions[uuid]
+		LDAPSession := GetSession(uuid).WithRequest(c)
 		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
 			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
 			return
 		}
 
 		status, res := LDAPSession.DelUserFromGroup(c.Param("userid"), c.Param("groupid"))
+		if status == http.StatusOK {
+			clearMembershipExpiry(fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn), fmt.Sprintf("uid=%s,%s", c.Param("userid"), LDAPSession.peopledn))
+		}
+		AuditMember(c, LDAPSession, "group.member_removed", fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn), fmt.Sprintf("uid=%s,%s", c.Param("userid"), LDAPSession.peopledn), status, res)
+		PublishEvent(LDAPSession, "group.member_removed", fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn), fmt.Sprintf("uid=%s,%s", c.Param("userid"), LDAPSession.peopledn), status)
 		c.JSON(status, HandleResponse(res))
 	})
 
-	log.Printf("Starting LDAP API on port %s\n", strconv.Itoa(config.ListenPort))
+	router.GET("/groups/:groupid/effective-members", func(c *gin.Context) {
+		session := sessions.Default(c)
+		SessionUUID := session.Get("SessionUUID")
+		if SessionUUID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		uuid := SessionUUID.(string)
+		LDAPSession := GetSession(uuid).WithRequest(c)
+		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+
+		status, res := LDAPSession.GetGroupEffectiveMembers(c.Param("groupid"))
+		c.JSON(status, HandleResponse(res))
+	})
 
-	err = router.Run("0.0.0.0:" + strconv.Itoa(config.ListenPort))
-	if err != nil {
-		log.Fatalf("Error starting router: %s", err.Error())
-	}
+	router.POST("/groups/:groupid/subgroups/:childid", func(c *gin.Context) {
+		session := sessions.Default(c)
+		SessionUUID := session.Get("SessionUUID")
+		if SessionUUID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		uuid := SessionUUID.(string)
+		LDAPSession := GetSession(uuid).WithRequest(c)
+		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+
+		status, res := LDAPSession.AddGroupToGroup(c.Param("childid"), c.Param("groupid"))
+		Audit(c, LDAPSession, "group.subgroup_added", fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn), []string{"member"}, status, res)
+		PublishEvent(LDAPSession, "group.subgroup_added", fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn), fmt.Sprintf("cn=%s,%s", c.Param("childid"), LDAPSession.groupsdn), status)
+		c.JSON(status, HandleResponse(res))
+	})
+
+	router.DELETE("/groups/:groupid/subgroups/:childid", func(c *gin.Context) {
+		session := sessions.Default(c)
+		SessionUUID := session.Get("SessionUUID")
+		if SessionUUID == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		uuid := SessionUUID.(string)
+		LDAPSession := GetSession(uuid).WithRequest(c)
+		if LDAPSession == nil { // does not have registered ldap session associated with cookie session
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+
+		status, res := LDAPSession.DelGroupFromGroup(c.Param("childid"), c.Param("groupid"))
+		Audit(c, LDAPSession, "group.subgroup_removed", fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn), []string{"member"}, status, res)
+		PublishEvent(LDAPSession, "group.subgroup_removed", fmt.Sprintf("cn=%s,%s", c.Param("groupid"), LDAPSession.groupsdn), fmt.Sprintf("cn=%s,%s", c.Param("childid"), LDAPSession.groupsdn), status)
+		c.JSON(status, HandleResponse(res))
+	})
 }
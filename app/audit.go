@@ -0,0 +1,257 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"log/syslog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// AuditEvent of a single mutating operation
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`
+	Target     string    `json:"target"`
+	Member     string    `json:"member,omitempty"` // member DN for group membership events
+	Attributes []string  `json:"attributes"`       // names of changed attributes, values are never recorded
+	Status     int       `json:"status"`
+	ResultCode uint16    `json:"resultCode"`
+	Result     string    `json:"result"`
+	ClientIP   string    `json:"clientIP"`
+}
+
+// AuditLogger appends audit events as json lines to a file and optionally to syslog
+type AuditLogger struct {
+	path   string
+	lock   sync.Mutex
+	file   *os.File
+	syslog *syslog.Writer
+}
+
+// returns a new AuditLogger writing to the path if not empty and to syslog if enabled
+func NewAuditLogger(path string, useSyslog bool) (*AuditLogger, error) {
+	logger := &AuditLogger{path: path}
+
+	if path != "" {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		logger.file = file
+	}
+
+	if useSyslog {
+		writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "proxmoxaas-ldap")
+		if err != nil {
+			return nil, err
+		}
+		logger.syslog = writer
+	}
+
+	return logger, nil
+}
+
+func (a *AuditLogger) Write(event AuditEvent) error {
+	content, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.file != nil {
+		_, err = a.file.Write(append(content, '\n'))
+		if err != nil {
+			return err
+		}
+	}
+	if a.syslog != nil {
+		err = a.syslog.Info(string(content))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AuditQuery filters for reading audit events, empty fields match any event
+type AuditQuery struct {
+	Actor  string    `form:"actor"`
+	Target string    `form:"target"`
+	Member string    `form:"member"`
+	Since  time.Time `form:"since"`
+	Until  time.Time `form:"until"`
+}
+
+// returns true if the event matches all of the non-empty query fields
+func (q AuditQuery) Matches(event AuditEvent) bool {
+	if q.Actor != "" && !strings.EqualFold(q.Actor, event.Actor) {
+		return false
+	}
+	if q.Target != "" && !strings.EqualFold(q.Target, event.Target) {
+		return false
+	}
+	if q.Member != "" && !strings.EqualFold(q.Member, event.Member) {
+		return false
+	}
+	if !q.Since.IsZero() && event.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && event.Time.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// returns all events in the audit log file matching the query
+func (a *AuditLogger) Read(query AuditQuery) ([]AuditEvent, error) {
+	if a.path == "" {
+		return nil, errors.New("audit log file is not enabled")
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	file, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	events := []AuditEvent{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil { // skip lines which are not valid events
+			continue
+		}
+		if query.Matches(event) {
+			events = append(events, event)
+		}
+	}
+	return events, scanner.Err()
+}
+
+// returns the audit events matching the query if the bound user is a member of the admin group
+func (l LDAPClient) GetAuditEvents(logger *AuditLogger, adminGroup string, query AuditQuery) (int, gin.H) {
+	if status, res := l.checkAdmin(adminGroup); status != http.StatusOK {
+		return status, res
+	}
+
+	events, err := logger.Read(query)
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{
+			"ok":    false,
+			"error": ldap.NewError(ldap.LDAPResultOther, err),
+		}
+	}
+	return http.StatusOK, gin.H{
+		"ok":     true,
+		"error":  nil,
+		"events": events,
+	}
+}
+
+// record an audit event for a mutating handler, must be called before HandleResponse since it reads the raw ldap error
+func Audit(c *gin.Context, session *LDAPClient, action string, target string, attributes []string, status int, res gin.H) {
+	if err := writeAudit(session, action, target, "", attributes, status, res, c.ClientIP()); err != nil {
+		c.Error(err) // audit failures should not fail the request which has already been applied
+	}
+}
+
+// record an audit event for a change to the membership of a single member in a group
+func AuditMember(c *gin.Context, session *LDAPClient, action string, groupDN string, memberDN string, status int, res gin.H) {
+	if err := writeAudit(session, action, groupDN, memberDN, []string{"member"}, status, res, c.ClientIP()); err != nil {
+		c.Error(err)
+	}
+}
+
+// record an audit event for an operation performed by a background job without a request, ie. account or membership expiry, member is empty unless a membership changed
+func AuditJob(session *LDAPClient, action string, target string, member string, attributes []string, status int, res gin.H) {
+	if err := writeAudit(session, action, target, member, attributes, status, res, ""); err != nil {
+		slog.Error("Error when writing audit event", "error", err)
+	}
+}
+
+func writeAudit(session *LDAPClient, action string, target string, member string, attributes []string, status int, res gin.H, clientIP string) error {
+	if AuditLog == nil {
+		return nil
+	}
+
+	event := AuditEvent{
+		Time:       time.Now().UTC(),
+		Actor:      session.binddn,
+		Action:     action,
+		Target:     target,
+		Member:     member,
+		Attributes: attributes,
+		Status:     status,
+		ResultCode: ldap.LDAPResultSuccess,
+		ClientIP:   clientIP,
+	}
+	if attributes == nil {
+		event.Attributes = []string{}
+	}
+	if err, ok := res["error"].(*ldap.Error); ok && err != nil {
+		event.ResultCode = err.ResultCode
+	}
+	event.Result = ldap.LDAPResultCodeMap[event.ResultCode]
+
+	return AuditLog.Write(event)
+}
+
+// returns the names of the attributes set in a user body
+func userAttributeNames(cn string, sn string, mail string, password string, expiresAt time.Time) []string {
+	attributes := []string{}
+	if cn != "" {
+		attributes = append(attributes, "cn")
+	}
+	if sn != "" {
+		attributes = append(attributes, "sn")
+	}
+	if mail != "" {
+		attributes = append(attributes, "mail")
+	}
+	if password != "" {
+		attributes = append(attributes, "userPassword")
+	}
+	if !expiresAt.IsZero() {
+		attributes = append(attributes, "expiresAt")
+	}
+	return attributes
+}
+
+// returns the names of the attributes set in a group body
+func groupAttributeNames(group Group) []string {
+	attributes := []string{}
+	if len(group.MemberURL) > 0 {
+		attributes = append(attributes, "memberURL")
+	}
+	if group.Description != "" {
+		attributes = append(attributes, "description")
+	}
+	if len(group.Owner) > 0 {
+		attributes = append(attributes, "owner")
+	}
+	if len(group.BusinessCategory) > 0 {
+		attributes = append(attributes, "businessCategory")
+	}
+	for name, values := range group.Attributes {
+		if len(values) > 0 {
+			attributes = append(attributes, name)
+		}
+	}
+	attributes = append(attributes, group.Clear...)
+	return attributes
+}
@@ -0,0 +1,369 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// prefix of environment variables which override config fields
+const EnvPrefix = "PAASLDAP_"
+
+// returns the config read from the path in the format of its extension (.json, .yaml, .yml, .toml) with environment overrides applied
+func GetConfig(configPath string) (Config, error) {
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return Config{}, err
+	}
+
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".yaml", ".yml":
+		content, err = yaml.YAMLToJSON(content)
+		if err != nil {
+			return Config{}, err
+		}
+	case ".toml":
+		var document map[string]any
+		err = toml.Unmarshal(content, &document)
+		if err != nil {
+			return Config{}, err
+		}
+		content, err = json.Marshal(document)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	var config Config
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields() // catch misspelled or removed fields
+	err = decoder.Decode(&config)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = ApplyEnvOverrides(&config)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if config.Audit.AdminGroup != "" { // deprecated since adminGroup also applies to the webhook and config endpoints
+		if config.AdminGroup != "" && config.AdminGroup != config.Audit.AdminGroup {
+			return Config{}, errors.New("audit.adminGroup: is deprecated and conflicts with adminGroup")
+		}
+		slog.Warn("audit.adminGroup is deprecated, use adminGroup instead")
+		config.AdminGroup = config.Audit.AdminGroup
+		config.Audit.AdminGroup = ""
+	}
+	return config, nil
+}
+
+// returns the environment variable name of a config field path ie. serviceAccount.bindDN -> PAASLDAP_SERVICE_ACCOUNT_BIND_DN
+func EnvName(path string) string {
+	var name strings.Builder
+	name.WriteString(EnvPrefix)
+	for i, field := range strings.Split(path, ".") {
+		if i > 0 {
+			name.WriteByte('_')
+		}
+		runes := []rune(field)
+		for j, r := range runes {
+			if j > 0 && unicode.IsUpper(r) {
+				previous := runes[j-1]
+				nextIsLower := j+1 < len(runes) && unicode.IsLower(runes[j+1])
+				if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextIsLower) {
+					name.WriteByte('_')
+				}
+			}
+			name.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return name.String()
+}
+
+// returns the json name of a struct field
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		name = strings.ToLower(field.Name[:1]) + field.Name[1:]
+	}
+	return name
+}
+
+// set every config field which has a PAASLDAP_* environment variable, lists are comma separated or json and maps are key=value pairs or json
+func ApplyEnvOverrides(config *Config) error {
+	return applyEnvOverrides(reflect.ValueOf(config).Elem(), "")
+}
+
+func applyEnvOverrides(value reflect.Value, path string) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fieldPath := fieldName(field)
+		if path != "" {
+			fieldPath = path + "." + fieldPath
+		}
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnvOverrides(value.Field(i), fieldPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := EnvName(fieldPath)
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setEnvValue(value.Field(i), env); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setEnvValue(value reflect.Value, env string) error {
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(env)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(env)
+		if err != nil {
+			return errors.New("must be a boolean")
+		}
+		value.SetBool(parsed)
+	case reflect.Int:
+		parsed, err := strconv.Atoi(env)
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		value.SetInt(int64(parsed))
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(env, 64)
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		value.SetFloat(parsed)
+	case reflect.Pointer: // optional values
+		elem := reflect.New(value.Type().Elem())
+		if err := setEnvValue(elem.Elem(), env); err != nil {
+			return err
+		}
+		value.Set(elem)
+	case reflect.Slice:
+		if strings.HasPrefix(strings.TrimSpace(env), "[") || value.Type().Elem().Kind() != reflect.String {
+			return json.Unmarshal([]byte(env), value.Addr().Interface())
+		}
+		items := []string{}
+		for item := range strings.SplitSeq(env, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		if strings.HasPrefix(strings.TrimSpace(env), "{") {
+			return json.Unmarshal([]byte(env), value.Addr().Interface())
+		}
+		items := map[string]string{}
+		for item := range strings.SplitSeq(env, ",") {
+			key, val, ok := strings.Cut(item, "=")
+			if !ok {
+				return errors.New("must be key=value pairs or a json object")
+			}
+			items[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+		value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+	return nil
+}
+
+// returns an error listing every invalid config field, or nil if the config is valid
+func (config Config) Validate() error {
+	var errs []error
+	invalid := func(field string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	nonNegative := func(field string, value int) {
+		if value < 0 {
+			invalid(field, "must not be negative")
+		}
+	}
+	oneOf := func(field string, value string, allowed ...string) {
+		if !slices.Contains(allowed, value) {
+			invalid(field, "must be one of %q, got %q", allowed, value)
+		}
+	}
+	validURL := func(field string, value string, schemes ...string) {
+		parsed, err := url.Parse(value)
+		if err != nil || parsed.Host == "" && parsed.Scheme != "ldapi" {
+			invalid(field, "must be a url, got %q", value)
+		} else if !slices.Contains(schemes, parsed.Scheme) {
+			invalid(field, "scheme must be one of %q, got %q", schemes, parsed.Scheme)
+		}
+	}
+	validDN := func(field string, value string) {
+		if _, err := ldap.ParseDN(value); err != nil {
+			invalid(field, "must be a DN: %s", err)
+		}
+	}
+
+	if config.ListenPort < 1 || config.ListenPort > 65535 {
+		invalid("listenPort", "must be between 1 and 65535, got %d", config.ListenPort)
+	}
+	if config.BindAddress != "" && net.ParseIP(config.BindAddress) == nil && config.BindAddress != "localhost" {
+		invalid("bindAddress", "must be an IP address, got %q", config.BindAddress)
+	}
+	if _, err := unixSocketMode(config.UnixSocket.Mode); err != nil {
+		invalid("unixSocket.mode", "%s, got %q", err, config.UnixSocket.Mode)
+	}
+	if (config.TLS.CertFile == "") != (config.TLS.KeyFile == "") {
+		invalid("tls", "certFile and keyFile must both be set to enable HTTPS")
+	}
+	if _, ok := tlsVersions[config.TLS.MinVersion]; !ok {
+		invalid("tls.minVersion", "must be one of %q, got %q", []string{"1.2", "1.3"}, config.TLS.MinVersion)
+	}
+	if _, err := cipherSuiteIDs(config.TLS.CipherSuites); err != nil {
+		invalid("tls.cipherSuites", "%s", err)
+	}
+	nonNegative("tls.reloadInterval", config.TLS.ReloadInterval)
+	if config.TLS.RedirectPort != 0 {
+		if config.TLS.CertFile == "" {
+			invalid("tls.redirectPort", "requires tls.certFile and tls.keyFile")
+		} else if config.TLS.RedirectPort < 1 || config.TLS.RedirectPort > 65535 || config.TLS.RedirectPort == config.ListenPort {
+			invalid("tls.redirectPort", "must be between 1 and 65535 and differ from listenPort, got %d", config.TLS.RedirectPort)
+		}
+	}
+	if config.TLS.ClientAuth.CAFile != "" {
+		if config.TLS.CertFile == "" {
+			invalid("tls.clientAuth.caFile", "requires tls.certFile and tls.keyFile")
+		}
+		if config.ServiceAccount.BindDN == "" || config.ServiceAccount.Password == "" {
+			invalid("tls.clientAuth.caFile", "requires serviceAccount.bindDN and serviceAccount.password")
+		}
+		if len(config.TLS.ClientAuth.Mappings) == 0 {
+			invalid("tls.clientAuth.mappings", "at least one mapping is required")
+		}
+	}
+	for i, mapping := range config.TLS.ClientAuth.Mappings {
+		oneOf(fmt.Sprintf("tls.clientAuth.mappings[%d].field", i), mapping.Field, clientCertFields...)
+		if _, err := mapping.regexp(); err != nil {
+			invalid(fmt.Sprintf("tls.clientAuth.mappings[%d].match", i), "%s", err)
+		}
+	}
+	if config.LdapURL == "" {
+		invalid("ldapURL", "is required")
+	} else {
+		validURL("ldapURL", config.LdapURL, "ldap", "ldaps", "ldapi")
+	}
+	if config.BaseDN == "" {
+		invalid("baseDN", "is required")
+	} else {
+		validDN("baseDN", config.BaseDN)
+	}
+	if config.GroupPlaceholderMember != "" {
+		validDN("groupPlaceholderMember", config.GroupPlaceholderMember)
+	}
+	for i, attr := range config.GroupAttributes {
+		if attr == "" {
+			invalid(fmt.Sprintf("groupAttributes[%d]", i), "must not be empty")
+		} else if slices.ContainsFunc(reservedGroupAttributes, func(reserved string) bool { return strings.EqualFold(reserved, attr) }) {
+			invalid(fmt.Sprintf("groupAttributes[%d]", i), "%q is managed by the API and cannot be an extra attribute", attr)
+		}
+	}
+
+	oneOf("log.level", strings.ToLower(config.Log.Level), "", "debug", "info", "warn", "error")
+	oneOf("log.format", config.Log.Format, "", "json", "text")
+
+	oneOf("tracing.exporter", config.Tracing.Exporter, "", "stdout", "otlp")
+	if config.Tracing.Exporter == "otlp" {
+		validURL("tracing.endpoint", config.Tracing.Endpoint, "http", "https")
+	}
+	nonNegative("tracing.interval", config.Tracing.Interval)
+	if ratio := config.Tracing.SampleRatio; ratio != nil && (*ratio < 0 || *ratio > 1) {
+		invalid("tracing.sampleRatio", "must be between 0 and 1")
+	}
+	nonNegative("tracing.maxQueueSize", config.Tracing.MaxQueueSize)
+
+	nonNegative("sessionStore.maxAge", config.SessionStore.MaxAge)
+
+	usesServiceAccount := config.AccountExpiry.Policy != "" || config.SoftDelete.Path != "" || config.MembershipExpiry.Path != "" || config.SessionStore.Path != ""
+	if usesServiceAccount && (config.ServiceAccount.BindDN == "" || config.ServiceAccount.Password == "") {
+		invalid("serviceAccount", "bindDN and password are required by accountExpiry.policy, softDelete, membershipExpiry, and sessionStore")
+	}
+	if config.ServiceAccount.BindDN != "" {
+		validDN("serviceAccount.bindDN", config.ServiceAccount.BindDN)
+	}
+
+	oneOf("accountExpiry.policy", config.AccountExpiry.Policy, "", "lock", "delete")
+	if config.AccountExpiry.Policy != "" && config.AccountExpiry.Attribute == "" {
+		invalid("accountExpiry.attribute", "is required when accountExpiry.policy is set")
+	}
+	nonNegative("accountExpiry.interval", config.AccountExpiry.Interval)
+
+	if config.SoftDelete.TrashDN != "" {
+		validDN("softDelete.trashDN", config.SoftDelete.TrashDN)
+	}
+	nonNegative("softDelete.retentionDays", config.SoftDelete.RetentionDays)
+	nonNegative("softDelete.interval", config.SoftDelete.Interval)
+
+	for i, subscriber := range config.Webhooks.Subscribers {
+		validURL(fmt.Sprintf("webhooks.subscribers[%d].url", i), subscriber.URL, "http", "https")
+	}
+	nonNegative("webhooks.maxAttempts", config.Webhooks.MaxAttempts)
+	nonNegative("webhooks.backoff", config.Webhooks.Backoff)
+	nonNegative("webhooks.timeout", config.Webhooks.Timeout)
+	nonNegative("webhooks.retentionDays", config.Webhooks.RetentionDays)
+
+	nonNegative("membershipExpiry.interval", config.MembershipExpiry.Interval)
+	nonNegative("shutdownTimeout", config.ShutdownTimeout)
+	nonNegative("reloadInterval", config.ReloadInterval)
+
+	if config.SessionCookieName == "" {
+		invalid("sessionCookieName", "is required")
+	}
+	nonNegative("sessionCookie.maxAge", config.SessionCookie.MaxAge)
+	oneOf("sessionCookie.sameSite", config.SessionCookie.SameSite, "", "lax", "strict", "none")
+	if config.SessionCookie.SameSite == "none" && !config.SessionCookie.Secure {
+		invalid("sessionCookie.sameSite", "none requires sessionCookie.secure")
+	}
+
+	for i, origin := range config.CORS.AllowedOrigins {
+		if origin == "*" {
+			if config.CORS.AllowCredentials {
+				invalid("cors.allowedOrigins", "* cannot be used with cors.allowCredentials, list the origins instead")
+			}
+			continue
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Host == "" || parsed.Path != "" || !slices.Contains([]string{"http", "https"}, parsed.Scheme) {
+			invalid(fmt.Sprintf("cors.allowedOrigins[%d]", i), "must be * or a scheme and host without a path ie. https://paas.domain.net, got %q", origin)
+		}
+	}
+	for i, method := range config.CORS.AllowedMethods {
+		oneOf(fmt.Sprintf("cors.allowedMethods[%d]", i), method, http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete)
+	}
+	nonNegative("cors.maxAge", config.CORS.MaxAge)
+
+	return errors.Join(errs...)
+}
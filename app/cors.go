@@ -0,0 +1,82 @@
+package app
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var defaultCORSMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+var defaultCORSHeaders = []string{"Content-Type", CSRFHeader, "X-Request-ID", "Last-Event-ID"}
+
+// response headers readable by scripts of allowed origins
+var corsExposedHeaders = []string{CSRFHeader, "X-Request-ID"}
+
+// SameSite attribute of the session cookie by sessionCookie.sameSite, none is needed to send the ticket from a frontend on another site
+var sameSiteModes = map[string]http.SameSite{
+	"":       http.SameSiteDefaultMode,
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// returns true if the origin is allowed by the origins, * allows every origin
+func corsOriginAllowed(origins []string, origin string) bool {
+	return slices.Contains(origins, "*") || slices.Contains(origins, origin)
+}
+
+// gin middleware which adds the CORS headers for allowed origins and answers preflight requests, requests without an Origin header are unchanged
+func CORSMiddleware(config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cors := currentConfig(config).CORS
+		origin := c.GetHeader("Origin")
+		if origin == "" || len(cors.AllowedOrigins) == 0 {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !corsOriginAllowed(cors.AllowedOrigins, origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next() // the browser blocks the response since it has no CORS headers
+			return
+		}
+
+		if slices.Contains(cors.AllowedOrigins, "*") && !cors.AllowCredentials {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+		if cors.AllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight {
+			c.Header("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+			c.Next()
+			return
+		}
+
+		methods := cors.AllowedMethods
+		if len(methods) == 0 {
+			methods = defaultCORSMethods
+		}
+		headers := cors.AllowedHeaders
+		if len(headers) == 0 {
+			headers = defaultCORSHeaders
+		}
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+		c.Header("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		c.Header("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+		if cors.MaxAge > 0 {
+			c.Header("Access-Control-Max-Age", strconv.Itoa(cors.MaxAge))
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
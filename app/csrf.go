@@ -0,0 +1,75 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// request header carrying the CSRF token issued by POST /ticket
+const CSRFHeader = "X-CSRF-Token"
+
+// returns a new random CSRF token
+func NewCSRFToken() string {
+	token := make([]byte, 32)
+	_, _ = rand.Read(token)
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// returns true if the request was sent by a browser from another site or an origin which is not explicitly listed in cors.allowedOrigins
+func crossOriginRequest(c *gin.Context, origins []string) bool {
+	switch c.GetHeader("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return false
+	}
+	if origin := c.GetHeader("Origin"); origin != "" {
+		return !slices.Contains(origins, origin) // the * wildcard does not allow credentials so it does not allow client certificates either
+	}
+	switch c.GetHeader("Sec-Fetch-Site") {
+	case "same-site", "cross-site":
+		return true
+	}
+	return false
+}
+
+// gin middleware which rejects state changing requests of ticket sessions whose X-CSRF-Token header does not match the token of the session and cross origin requests of client certificate sessions, must run after ClientCertMiddleware
+func CSRFMiddleware(config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		config := currentConfig(config)
+		if !config.CSRF.Enabled || c.Request.Method == http.MethodPost && c.FullPath() == "/ticket" { // logging in issues a new token
+			c.Next()
+			return
+		}
+		session := sessions.Default(c)
+		uuid, _ := session.Get("SessionUUID").(string)
+		if uuid == "" { // only requests authenticated by the ticket cookie or a client certificate are sent by browsers automatically
+			c.Next()
+			return
+		}
+		if strings.HasPrefix(uuid, clientCertSessionPrefix) { // browsers also present client certificates to other sites, which cannot obtain a token
+			if crossOriginRequest(c, config.CORS.AllowedOrigins) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "cross origin requests authenticated by a client certificate are not allowed"})
+				return
+			}
+			c.Next()
+			return
+		}
+		token, _ := session.Get("CSRFToken").(string)
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(c.GetHeader(CSRFHeader))) != 1 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "missing or invalid " + CSRFHeader + " header"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// DirectoryEvent of a change to a user or group observed through LDAP content synchronization (RFC 4533)
+type DirectoryEvent struct {
+	Type   string // user.created, user.updated, user.deleted, user.present, the group equivalents, or sync.ready and sync.cookie
+	DN     string
+	Entry  gin.H  // user or group as returned by LDAPUserToGin or LDAPGroupToGin, nil for deleted entries
+	Cookie []byte // sync cookie to resume after this event, nil if the server did not send one
+}
+
+// returns "user" or "group" depending on the object class of the entry, or the parent DN of deleted entries which have no attributes
+func SyncEntryKind(entry *ldap.Entry, peopledn string, groupsdn string) string {
+	for _, objectClass := range entry.GetAttributeValues("objectClass") {
+		switch strings.ToLower(objectClass) {
+		case "inetorgperson":
+			return "user"
+		case "groupofnames", "groupofurls":
+			return "group"
+		}
+	}
+	dn := strings.ToLower(entry.DN)
+	if strings.HasSuffix(dn, ","+strings.ToLower(peopledn)) {
+		return "user"
+	}
+	if strings.HasSuffix(dn, ","+strings.ToLower(groupsdn)) {
+		return "group"
+	}
+	return ""
+}
+
+// returns the event type of an entry with the sync state
+func SyncEventType(kind string, state ldap.ControlSyncStateState) string {
+	switch state {
+	case ldap.SyncStateAdd:
+		return kind + ".created"
+	case ldap.SyncStateModify:
+		return kind + ".updated"
+	case ldap.SyncStateDelete:
+		return kind + ".deleted"
+	default:
+		return kind + ".present"
+	}
+}
+
+// returns the sync cookie encoded for use as an SSE event id
+func EncodeSyncCookie(cookie []byte) string {
+	return base64.RawURLEncoding.EncodeToString(cookie)
+}
+
+// returns the sync cookie from an SSE event id, or nil if the id is empty
+func DecodeSyncCookie(id string) ([]byte, error) {
+	if id == "" {
+		return nil, nil
+	}
+	return base64.RawURLEncoding.DecodeString(id)
+}
+
+// write the event in the text/event-stream format, the cookie is sent as the event id so that clients resume with Last-Event-ID
+func WriteSSE(w io.Writer, event DirectoryEvent) error {
+	data, err := json.Marshal(gin.H{
+		"dn":    event.DN,
+		"entry": event.Entry,
+	})
+	if err != nil {
+		return err
+	}
+	if event.Cookie != nil {
+		if _, err := fmt.Fprintf(w, "id: %s\n", EncodeSyncCookie(event.Cookie)); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
+
+// returns the event for an entry sent by the sync, or false if the entry is neither a user nor a group
+func (l LDAPClient) syncEntryEvent(entry *ldap.Entry, state ldap.ControlSyncStateState) (DirectoryEvent, bool) {
+	kind := SyncEntryKind(entry, l.peopledn, l.groupsdn)
+	if kind == "" {
+		return DirectoryEvent{}, false
+	}
+
+	event := DirectoryEvent{
+		Type: SyncEventType(kind, state),
+		DN:   entry.DN,
+	}
+	if state == ldap.SyncStateDelete {
+		return event, true
+	}
+
+	switch kind {
+	case "user":
+		user := LDAPEntryToLDAPUser(entry)
+		user.Attributes.ExpiresAt = l.getExpiry(entry)
+		event.Entry = LDAPUserToGin(user)
+	case "group":
+		group, err := l.resolveDynamicMembers(LDAPEntryToLDAPGroup(entry))
+		if err != nil { // still send the event with the static attributes
+			group = LDAPEntryToLDAPGroup(entry)
+		}
+		group.Attributes.Member = l.hidePlaceholderMembers(group.DN, group.Attributes.Member)
+		event.Entry = LDAPGroupToGin(group)
+	}
+	return event, true
+}
+
+// stream changes to users and groups under the base DN to send until the context is cancelled or send returns an error,
+// without a cookie the initial content is only sent if initial is true, otherwise only changes since the cookie are sent
+func (l LDAPClient) SyncEvents(ctx context.Context, cookie []byte, initial bool, send func(DirectoryEvent) error) error {
+	attributes := append(l.userAttributeList(), l.groupAttributeList()...)
+	attributes = append(attributes, "objectClass")
+	searchRequest := ldap.NewSearchRequest(
+		l.basedn, // The base dn to search
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		"(|(objectClass=inetOrgPerson)(objectClass=groupOfNames)(objectClass=groupOfURLs))", // The filter to apply
+		attributes, // A list attributes to retrieve
+		nil,
+	)
+
+	refreshing := len(cookie) == 0 && !initial // suppress the initial content until the refresh phase is done
+	searchRequest.Controls = l.withAuthz(searchRequest.Controls)
+	response := l.client.Syncrepl(ctx, searchRequest, 64, ldap.SyncRequestModeRefreshAndPersist, cookie, false)
+	for response.Next() {
+		state := ldap.SyncStatePresent
+		var newCookie []byte
+		refreshDone := false
+		for _, control := range response.Controls() {
+			switch control := control.(type) {
+			case *ldap.ControlSyncState:
+				state = control.State
+				newCookie = control.Cookie
+			case *ldap.ControlSyncInfo:
+				switch control.Value {
+				case ldap.SyncInfoNewcookie:
+					newCookie = control.NewCookie.Cookie
+				case ldap.SyncInfoRefreshDelete:
+					newCookie = control.RefreshDelete.Cookie
+					refreshDone = control.RefreshDelete.RefreshDone
+				case ldap.SyncInfoRefreshPresent:
+					newCookie = control.RefreshPresent.Cookie
+					refreshDone = control.RefreshPresent.RefreshDone
+				case ldap.SyncInfoSyncIdSet:
+					newCookie = control.SyncIdSet.Cookie
+				}
+			case *ldap.ControlSyncDone:
+				newCookie = control.Cookie
+			}
+		}
+
+		if response.Entry() != nil {
+			event, ok := l.syncEntryEvent(response.Entry(), state)
+			if !ok || refreshing {
+				continue
+			}
+			event.Cookie = newCookie
+			if err := send(event); err != nil {
+				return err
+			}
+		} else if refreshDone || newCookie != nil { // sync info without an entry, forward the cookie so clients can resume from it
+			eventType := "sync.cookie"
+			if refreshDone {
+				eventType = "sync.ready"
+				refreshing = false
+			}
+			if err := send(DirectoryEvent{Type: eventType, Cookie: newCookie}); err != nil {
+				return err
+			}
+		}
+	}
+	return response.Err()
+}
@@ -0,0 +1,355 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// MembershipExpiry of a single member in a group
+type MembershipExpiry struct {
+	GroupDN   string    `json:"groupDN"`
+	MemberDN  string    `json:"memberDN"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// MembershipExpiryStore persists membership expiries to a json file
+type MembershipExpiryStore struct {
+	path     string
+	lock     sync.Mutex
+	expiries map[string]MembershipExpiry
+}
+
+// returns a new MembershipExpiryStore loaded from the path, or an empty store if the file does not exist
+func NewMembershipExpiryStore(path string) (*MembershipExpiryStore, error) {
+	store := &MembershipExpiryStore{
+		path:     path,
+		expiries: make(map[string]MembershipExpiry),
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var expiries []MembershipExpiry
+	err = json.Unmarshal(content, &expiries)
+	if err != nil {
+		return nil, err
+	}
+	for _, expiry := range expiries {
+		store.expiries[membershipKey(expiry.GroupDN, expiry.MemberDN)] = expiry
+	}
+
+	return store, nil
+}
+
+func membershipKey(groupDN string, memberDN string) string {
+	return strings.ToLower(groupDN) + "|" + strings.ToLower(memberDN)
+}
+
+func (s *MembershipExpiryStore) save() error {
+	expiries := []MembershipExpiry{}
+	for _, expiry := range s.expiries {
+		expiries = append(expiries, expiry)
+	}
+
+	content, err := json.Marshal(expiries)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, content)
+}
+
+func (s *MembershipExpiryStore) Set(groupDN string, memberDN string, expiresAt time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.expiries[membershipKey(groupDN, memberDN)] = MembershipExpiry{
+		GroupDN:   groupDN,
+		MemberDN:  memberDN,
+		ExpiresAt: expiresAt.UTC(),
+	}
+	return s.save()
+}
+
+func (s *MembershipExpiryStore) Delete(groupDN string, memberDN string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := membershipKey(groupDN, memberDN)
+	if _, ok := s.expiries[key]; !ok {
+		return nil
+	}
+	delete(s.expiries, key)
+	return s.save()
+}
+
+// delete all expiries where the DN is either the group or the member, used when an entry is deleted
+func (s *MembershipExpiryStore) DeleteDN(dn string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	changed := false
+	for key, expiry := range s.expiries {
+		if strings.EqualFold(expiry.GroupDN, dn) || strings.EqualFold(expiry.MemberDN, dn) {
+			delete(s.expiries, key)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.save()
+}
+
+// returns a map of member DN to expiry time for the group
+func (s *MembershipExpiryStore) ForGroup(groupDN string) map[string]time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	result := make(map[string]time.Time)
+	for _, expiry := range s.expiries {
+		if strings.EqualFold(expiry.GroupDN, groupDN) {
+			result[expiry.MemberDN] = expiry.ExpiresAt
+		}
+	}
+	return result
+}
+
+// returns a map of group DN to expiry time for the member
+func (s *MembershipExpiryStore) ForMember(memberDN string) map[string]time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	result := make(map[string]time.Time)
+	for _, expiry := range s.expiries {
+		if strings.EqualFold(expiry.MemberDN, memberDN) {
+			result[expiry.GroupDN] = expiry.ExpiresAt
+		}
+	}
+	return result
+}
+
+// returns all expiries which have passed at the given time
+func (s *MembershipExpiryStore) Expired(now time.Time) []MembershipExpiry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	result := []MembershipExpiry{}
+	for _, expiry := range s.expiries {
+		if !expiry.ExpiresAt.After(now) {
+			result = append(result, expiry)
+		}
+	}
+	return result
+}
+
+// add the memberExpiry attribute to a group returned by LDAPGroupToGin
+func (s *MembershipExpiryStore) AnnotateGroup(group gin.H) {
+	attributes := group["attributes"].(gin.H)
+	attributes["memberExpiry"] = s.ForGroup(group["dn"].(string))
+}
+
+// add the memberOfExpiry attribute to a user returned by LDAPUserToGin
+func (s *MembershipExpiryStore) AnnotateUser(user gin.H) {
+	attributes := user["attributes"].(gin.H)
+	attributes["memberOfExpiry"] = s.ForMember(user["dn"].(string))
+}
+
+// remove the expiry of a membership if membership expiry is enabled, called whenever a membership is changed without an expiry
+func clearMembershipExpiry(groupDN string, memberDN string) {
+	if MembershipExpiries == nil {
+		return
+	}
+	err := MembershipExpiries.Delete(groupDN, memberDN)
+	if err != nil {
+		slog.Error("Error when saving membership expiries", "error", err)
+	}
+}
+
+// set the expiry of an existing member of a group, the member value is removed and added in the same modify so that only users allowed to change the members can change the expiry
+func (l LDAPClient) SetGroupMemberExpiry(uid string, gid string, expiresAt time.Time) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "SetGroupMemberExpiry", time.Now(), &res)
+	userDN := fmt.Sprintf("uid=%s,%s", uid, l.peopledn)
+	groupDN := fmt.Sprintf("cn=%s,%s", gid, l.groupsdn)
+
+	modifyRequest := ldap.NewModifyRequest(groupDN, nil)
+	modifyRequest.Delete("member", []string{userDN})
+	modifyRequest.Add("member", []string{userDN})
+	err := l.modify(modifyRequest)
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+
+	err = MembershipExpiries.Set(groupDN, userDN, expiresAt)
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{
+			"ok":    false,
+			"error": ldap.NewError(ldap.LDAPResultOther, err),
+		}
+	}
+
+	return http.StatusOK, gin.H{
+		"ok":    true,
+		"error": nil,
+	}
+}
+
+// remove all expiries of a deleted entry if membership expiry is enabled
+func clearEntryExpiries(dn string) {
+	if MembershipExpiries == nil {
+		return
+	}
+	err := MembershipExpiries.DeleteDN(dn)
+	if err != nil {
+		slog.Error("Error when saving membership expiries", "error", err)
+	}
+}
+
+// returns the expiries where the DN is either the group or the member, saved when an entry is soft deleted
+func entryExpiries(dn string) []MembershipExpiry {
+	expiries := []MembershipExpiry{}
+	if MembershipExpiries == nil {
+		return expiries
+	}
+	for memberDN, expiresAt := range MembershipExpiries.ForGroup(dn) {
+		expiries = append(expiries, MembershipExpiry{GroupDN: dn, MemberDN: memberDN, ExpiresAt: expiresAt})
+	}
+	for groupDN, expiresAt := range MembershipExpiries.ForMember(dn) {
+		expiries = append(expiries, MembershipExpiry{GroupDN: groupDN, MemberDN: dn, ExpiresAt: expiresAt})
+	}
+	return expiries
+}
+
+// set the expiries of restored memberships again if membership expiry is enabled, expiries which have passed are removed by the next expiry check
+func restoreExpiries(expiries []MembershipExpiry, restored func(MembershipExpiry) bool) {
+	if MembershipExpiries == nil {
+		return
+	}
+	for _, expiry := range expiries {
+		if !restored(expiry) {
+			continue
+		}
+		if err := MembershipExpiries.Set(expiry.GroupDN, expiry.MemberDN, expiry.ExpiresAt); err != nil {
+			slog.Error("Error when saving membership expiries", "error", err)
+		}
+	}
+}
+
+// remove all expired memberships using the service account, memberships which no longer exist are also removed from the store
+func ExpireMemberships(config Config, store *MembershipExpiryStore, now time.Time) {
+	expired := store.Expired(now)
+	if len(expired) == 0 {
+		return
+	}
+
+	client, err := NewServiceLDAPClient(config)
+	if err != nil {
+		slog.Error("Error when expiring memberships", "error", err)
+		return
+	}
+	defer client.client.Close()
+
+	for _, expiry := range expired {
+		status, res := client.delGroupMember(expiry.MemberDN, expiry.GroupDN)
+		AuditJob(client, "group.member_removed", expiry.GroupDN, expiry.MemberDN, []string{"member"}, status, res)
+		if status != http.StatusOK {
+			err := res["error"].(error)
+			if !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) {
+				slog.Error("Error when expiring membership", "member", expiry.MemberDN, "group", expiry.GroupDN, "error", err)
+				continue
+			}
+		}
+		slog.Info("Expired membership", "member", expiry.MemberDN, "group", expiry.GroupDN)
+		PublishEvent(client, "group.member_removed", expiry.GroupDN, expiry.MemberDN, http.StatusOK)
+		err = store.Delete(expiry.GroupDN, expiry.MemberDN)
+		if err != nil {
+			slog.Error("Error when saving membership expiries", "error", err)
+		}
+	}
+}
+
+// run ExpireMemberships at the configured interval
+func RunMembershipExpiry(config Config, store *MembershipExpiryStore) {
+	interval := time.Duration(config.MembershipExpiry.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		ExpireMemberships(currentConfig(config), store, time.Now()) // use the reloaded ldap and service account settings
+	}
+}
+
+// lock or delete all expired accounts using the service account according to the configured policy
+func ExpireAccounts(config Config, now time.Time) {
+	client, err := NewServiceLDAPClient(config)
+	if err != nil {
+		slog.Error("Error when expiring accounts", "error", err)
+		return
+	}
+	defer client.client.Close()
+
+	entries, err := client.getUsersWithExpiry()
+	if err != nil {
+		slog.Error("Error when expiring accounts", "error", err)
+		return
+	}
+
+	for _, entry := range entries {
+		expiresAt := client.getExpiry(entry)
+		if expiresAt == nil || expiresAt.After(now) {
+			continue
+		}
+
+		switch config.AccountExpiry.Policy {
+		case "lock":
+			if entry.GetAttributeValue("pwdAccountLockedTime") != "" { // already locked
+				continue
+			}
+			modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
+			modifyRequest.Replace("pwdAccountLockedTime", []string{"000001010000Z"}) // permanently locked until an admin unlocks the account
+			err := client.modify(modifyRequest)
+			status, res := http.StatusOK, gin.H{"ok": true, "error": nil}
+			if err != nil {
+				status, res = http.StatusBadRequest, gin.H{"ok": false, "error": err}
+			}
+			AuditJob(client, "user.lock", entry.DN, "", []string{"pwdAccountLockedTime"}, status, res)
+			if err != nil {
+				slog.Error("Error when locking expired account", "dn", entry.DN, "error", err)
+				continue
+			}
+			slog.Info("Locked expired account", "dn", entry.DN)
+		case "delete":
+			status, res := client.deleteUser(entry.GetAttributeValue("uid"))
+			AuditJob(client, "user.delete", entry.DN, "", nil, status, res)
+			if status != http.StatusOK {
+				slog.Error("Error when deleting expired account", "dn", entry.DN, "error", res["error"])
+				continue
+			}
+			slog.Info("Deleted expired account", "dn", entry.DN)
+			PublishEvent(client, "user.deleted", entry.DN, "", status)
+		}
+	}
+}
+
+// run ExpireAccounts at the configured interval
+func RunAccountExpiry(config Config) {
+	interval := time.Duration(config.AccountExpiry.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		ExpireAccounts(currentConfig(config), time.Now())
+	}
+}
@@ -1,61 +1,134 @@
 package app
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-ldap/ldap/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// LDAPClient wrapper struct containing the connection, baseDN, peopleDN, and groupsDN
+// LDAPClient wrapper struct containing the connection, baseDN, peopleDN, groupsDN, and group options from the config
 type LDAPClient struct {
-	client   *ldap.Conn
-	basedn   string
-	peopledn string
-	groupsdn string
+	client    *ldap.Conn
+	basedn    string
+	peopledn  string
+	groupsdn  string
+	url       string
+	config    *Config // config the session was created with, options which do not change the connection are read from the reloaded config by options
+	binddn    string
+	authzid   string          // authorization identity of every operation using proxied authorization, set for client certificate sessions
+	expiresAt time.Time       // end of a session persisted by sessionStore, zero if the session does not expire
+	requestid string          // request ID of the gin request using this session, set by WithRequest
+	ctx       context.Context // context of the gin request using this session used as the parent of LDAP spans, set by WithRequest
 }
 
+// LDAP_MATCHING_RULE_IN_CHAIN used to resolve nested group membership on servers which support it
+const LDAPMatchingRuleInChain = "1.2.840.113556.1.4.1941"
+
 // returns a new LDAPClient from the config
 func NewLDAPClient(config Config) (*LDAPClient, error) {
+	return NewLDAPClientWithContext(context.Background(), config)
+}
+
+// returns a new LDAPClient from the config, the dial is traced as a child of the span in the context
+func NewLDAPClientWithContext(ctx context.Context, config Config) (*LDAPClient, error) {
+	_, span := tracer().Start(ctx, "ldap.dial", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("ldap.url", config.LdapURL)))
 	LDAPConn, err := ldap.DialURL(config.LdapURL)
 	if err != nil {
+		LDAPDialErrors.Inc()
+		finishLDAPSpan(span, err)
 		return nil, err
 	}
 
 	if config.StartTLS {
 		err = LDAPConn.StartTLS(&tls.Config{InsecureSkipVerify: true})
 		if err != nil {
+			LDAPDialErrors.Inc()
+			finishLDAPSpan(span, err)
+			LDAPConn.Close()
 			return nil, err
 		}
 	}
+	finishLDAPSpan(span, nil)
 
 	return &LDAPClient{
 		client:   LDAPConn,
 		basedn:   config.BaseDN,
 		peopledn: "ou=people," + config.BaseDN,
 		groupsdn: "ou=groups," + config.BaseDN,
+		url:      config.LdapURL,
+		config:   &config,
+		ctx:      ctx,
 	}, err
 }
 
+// returns a new LDAPClient from the config bound as the service account, used by background jobs
+func NewServiceLDAPClient(config Config) (*LDAPClient, error) {
+	client, err := NewLDAPClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.bind(config.ServiceAccount.BindDN, config.ServiceAccount.Password)
+	if err != nil {
+		client.client.Close()
+		return nil, err
+	}
+	client.binddn = config.ServiceAccount.BindDN
+
+	return client, nil
+}
+
+// returns the active config, group and account expiry options apply to existing sessions when the config is reloaded while the connection and base DN are kept
+func (l LDAPClient) options() Config {
+	return currentConfig(*l.config)
+}
+
+// returns the attribute storing the account expiry, or empty if account expiry is disabled
+func (l LDAPClient) expiryAttribute() string {
+	return l.options().AccountExpiry.Attribute
+}
+
+// returns the DN of the trash container under the base DN of the session
+func (l LDAPClient) trashDN() string {
+	trashDN := l.options().SoftDelete.TrashDN
+	if trashDN == "" {
+		trashDN = "ou=trash"
+	}
+	return trashDN + "," + l.basedn
+}
+
 // bind a user using username and password to the LDAPClient
-func (l LDAPClient) BindUser(username string, password string) error {
+func (l *LDAPClient) BindUser(username string, password string) error {
 	userdn := fmt.Sprintf("uid=%s,%s", username, l.peopledn)
-	return l.client.Bind(userdn, password)
+	err := l.bind(userdn, password)
+	if err == nil {
+		l.binddn = userdn
+	}
+	return err
 }
 
-func (l LDAPClient) GetAllUsers() (int, gin.H) {
+func (l LDAPClient) GetAllUsers() (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "GetAllUsers", time.Now(), &res)
 	searchRequest := ldap.NewSearchRequest(
 		l.peopledn, // The base dn to search
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		"(&(objectClass=inetOrgPerson))",                      // The filter to apply
-		[]string{"dn", "cn", "sn", "mail", "uid", "memberOf"}, // A list attributes to retrieve
+		"(&(objectClass=inetOrgPerson))", // The filter to apply
+		l.userAttributeList(),            // A list attributes to retrieve
 		nil,
 	)
 
-	searchResponse, err := l.client.Search(searchRequest) // perform search
+	searchResponse, err := l.search(searchRequest) // perform search
 	if err != nil {
 		return http.StatusBadRequest, gin.H{
 			"ok":    false,
@@ -67,6 +140,7 @@ func (l LDAPClient) GetAllUsers() (int, gin.H) {
 
 	for _, entry := range searchResponse.Entries { // for each result,
 		user := LDAPEntryToLDAPUser(entry)
+		user.Attributes.ExpiresAt = l.getExpiry(entry)
 		results = append(results, LDAPUserToGin(user))
 	}
 
@@ -77,16 +151,17 @@ func (l LDAPClient) GetAllUsers() (int, gin.H) {
 	}
 }
 
-func (l LDAPClient) GetUser(uid string) (int, gin.H) {
+func (l LDAPClient) GetUser(uid string) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "GetUser", time.Now(), &res)
 	searchRequest := ldap.NewSearchRequest( //  setup search for user by uid
 		fmt.Sprintf("uid=%s,%s", uid, l.peopledn), // The base dn to search
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		"(&(objectClass=inetOrgPerson))",                      // The filter to apply
-		[]string{"dn", "cn", "sn", "mail", "uid", "memberOf"}, // A list attributes to retrieve
+		"(&(objectClass=inetOrgPerson))", // The filter to apply
+		l.userAttributeList(),            // A list attributes to retrieve
 		nil,
 	)
 
-	searchResponse, err := l.client.Search(searchRequest) // perform search
+	searchResponse, err := l.search(searchRequest) // perform search
 	if err != nil {
 		return http.StatusBadRequest, gin.H{
 			"ok":    false,
@@ -97,6 +172,7 @@ func (l LDAPClient) GetUser(uid string) (int, gin.H) {
 	entry := searchResponse.Entries[0]
 
 	user := LDAPEntryToLDAPUser(entry)
+	user.Attributes.ExpiresAt = l.getExpiry(entry)
 	result := LDAPUserToGin(user)
 
 	return http.StatusOK, gin.H{
@@ -106,7 +182,8 @@ func (l LDAPClient) GetUser(uid string) (int, gin.H) {
 	}
 }
 
-func (l LDAPClient) AddUser(uid string, user UserRequired) (int, gin.H) {
+func (l LDAPClient) AddUser(uid string, user UserRequired) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "AddUser", time.Now(), &res)
 	if user.CN == "" || user.SN == "" || user.UserPassword == "" || user.Mail == "" {
 		return http.StatusBadRequest, gin.H{
 			"ok": false,
@@ -117,6 +194,7 @@ func (l LDAPClient) AddUser(uid string, user UserRequired) (int, gin.H) {
 		}
 	}
 
+	expiryattr := l.expiryAttribute()
 	addRequest := ldap.NewAddRequest(
 		fmt.Sprintf("uid=%s,%s", uid, l.peopledn), // DN
 		nil, // controls
@@ -125,9 +203,22 @@ func (l LDAPClient) AddUser(uid string, user UserRequired) (int, gin.H) {
 	addRequest.Attribute("cn", []string{user.CN})
 	addRequest.Attribute("mail", []string{user.Mail})
 	addRequest.Attribute("userPassword", []string{user.UserPassword})
-	addRequest.Attribute("objectClass", []string{"inetOrgPerson"})
+	if !user.ExpiresAt.IsZero() {
+		if expiryattr == "" {
+			return http.StatusBadRequest, gin.H{
+				"ok":    false,
+				"error": ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("account expiry is not enabled")),
+			}
+		}
+		addRequest.Attribute(expiryattr, []string{FormatExpiry(expiryattr, user.ExpiresAt)})
+	}
+	if strings.EqualFold(expiryattr, "shadowExpire") && !user.ExpiresAt.IsZero() { // shadowExpire requires the shadowAccount auxiliary class
+		addRequest.Attribute("objectClass", []string{"inetOrgPerson", "shadowAccount"})
+	} else {
+		addRequest.Attribute("objectClass", []string{"inetOrgPerson"})
+	}
 
-	err := l.client.Add(addRequest)
+	err := l.add(addRequest)
 	if err != nil {
 		return http.StatusBadRequest, gin.H{
 			"ok":    false,
@@ -141,17 +232,19 @@ func (l LDAPClient) AddUser(uid string, user UserRequired) (int, gin.H) {
 	}
 }
 
-func (l LDAPClient) ModUser(uid string, user UserOptional) (int, gin.H) {
-	if user.CN == "" && user.SN == "" && user.UserPassword == "" && user.Mail == "" {
+func (l LDAPClient) ModUser(uid string, user UserOptional) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "ModUser", time.Now(), &res)
+	if user.CN == "" && user.SN == "" && user.UserPassword == "" && user.Mail == "" && user.ExpiresAt.IsZero() {
 		return http.StatusBadRequest, gin.H{
 			"ok": false,
 			"error": ldap.NewError(
 				ldap.LDAPResultUnwillingToPerform,
-				errors.New("requires one of fields: cn, sn, mail, userpassword"),
+				errors.New("requires one of fields: cn, sn, mail, userpassword, expiresAt"),
 			),
 		}
 	}
 
+	expiryattr := l.expiryAttribute()
 	modifyRequest := ldap.NewModifyRequest(
 		fmt.Sprintf("uid=%s,%s", uid, l.peopledn),
 		nil,
@@ -168,8 +261,29 @@ func (l LDAPClient) ModUser(uid string, user UserOptional) (int, gin.H) {
 	if user.UserPassword != "" {
 		modifyRequest.Replace("userPassword", []string{user.UserPassword})
 	}
+	if !user.ExpiresAt.IsZero() {
+		if expiryattr == "" {
+			return http.StatusBadRequest, gin.H{
+				"ok":    false,
+				"error": ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("account expiry is not enabled")),
+			}
+		}
+		if strings.EqualFold(expiryattr, "shadowExpire") { // shadowExpire requires the shadowAccount auxiliary class
+			objectClasses, err := l.getObjectClasses(fmt.Sprintf("uid=%s,%s", uid, l.peopledn))
+			if err != nil {
+				return http.StatusBadRequest, gin.H{
+					"ok":    false,
+					"error": err,
+				}
+			}
+			if !objectClasses["shadowaccount"] {
+				modifyRequest.Add("objectClass", []string{"shadowAccount"})
+			}
+		}
+		modifyRequest.Replace(expiryattr, []string{FormatExpiry(expiryattr, user.ExpiresAt)})
+	}
 
-	err := l.client.Modify(modifyRequest)
+	err := l.modify(modifyRequest)
 	if err != nil {
 		return http.StatusBadRequest, gin.H{
 			"ok":    false,
@@ -183,7 +297,69 @@ func (l LDAPClient) ModUser(uid string, user UserOptional) (int, gin.H) {
 	}
 }
 
-func (l LDAPClient) DelUser(uid string) (int, gin.H) {
+// returns true if the user has an account expiry which has passed, always false if account expiry is not enabled
+func (l LDAPClient) IsUserExpired(uid string, now time.Time) (bool, error) {
+	expiryattr := l.expiryAttribute()
+	if expiryattr == "" {
+		return false, nil
+	}
+
+	searchRequest := ldap.NewSearchRequest( //  setup search for user by uid
+		fmt.Sprintf("uid=%s,%s", uid, l.peopledn), // The base dn to search
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(&(objectClass=inetOrgPerson))", // The filter to apply
+		[]string{expiryattr},             // A list attributes to retrieve
+		nil,
+	)
+
+	searchResponse, err := l.search(searchRequest) // perform search
+	if err != nil {
+		return false, err
+	}
+
+	expiresAt := l.getExpiry(searchResponse.Entries[0])
+	return expiresAt != nil && !expiresAt.After(now), nil
+}
+
+func (l LDAPClient) GetExpiringUsers(days int) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "GetExpiringUsers", time.Now(), &res)
+	if l.expiryAttribute() == "" {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("account expiry is not enabled")),
+		}
+	}
+
+	entries, err := l.getUsersWithExpiry()
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+
+	now := time.Now()
+	until := now.AddDate(0, 0, days)
+	var results = []gin.H{} // create list of results
+
+	for _, entry := range entries {
+		user := LDAPEntryToLDAPUser(entry)
+		user.Attributes.ExpiresAt = l.getExpiry(entry)
+		if user.Attributes.ExpiresAt == nil || !user.Attributes.ExpiresAt.After(now) || user.Attributes.ExpiresAt.After(until) {
+			continue
+		}
+		results = append(results, LDAPUserToGin(user))
+	}
+
+	return http.StatusOK, gin.H{
+		"ok":    true,
+		"error": nil,
+		"users": results,
+	}
+}
+
+func (l LDAPClient) DelUser(uid string) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "DelUser", time.Now(), &res)
 	userDN := fmt.Sprintf("uid=%s,%s", uid, l.peopledn)
 
 	// assumes that olcMemberOfRefint=true updates member attributes of referenced groups
@@ -193,7 +369,7 @@ func (l LDAPClient) DelUser(uid string) (int, gin.H) {
 		nil,
 	)
 
-	err := l.client.Del(deleteUserRequest) // delete user
+	err := l.del(deleteUserRequest) // delete user
 	if err != nil {
 		return http.StatusBadRequest, gin.H{
 			"ok":    false,
@@ -207,16 +383,17 @@ func (l LDAPClient) DelUser(uid string) (int, gin.H) {
 	}
 }
 
-func (l LDAPClient) GetAllGroups() (int, gin.H) {
+func (l LDAPClient) GetAllGroups() (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "GetAllGroups", time.Now(), &res)
 	searchRequest := ldap.NewSearchRequest(
 		l.groupsdn, // The base dn to search
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		"(&(objectClass=groupOfNames))", // The filter to apply
-		[]string{"cn", "member"},        // A list attributes to retrieve
+		"(|(objectClass=groupOfNames)(objectClass=groupOfURLs))", // The filter to apply
+		l.groupAttributeList(), // A list attributes to retrieve
 		nil,
 	)
 
-	searchResponse, err := l.client.Search(searchRequest) // perform search
+	searchResponse, err := l.search(searchRequest) // perform search
 	if err != nil {
 		return http.StatusBadRequest, gin.H{
 			"ok":    false,
@@ -228,6 +405,14 @@ func (l LDAPClient) GetAllGroups() (int, gin.H) {
 
 	for _, entry := range searchResponse.Entries { // for each result,
 		group := LDAPEntryToLDAPGroup(entry)
+		group.Attributes.Member = l.hidePlaceholderMembers(group.DN, group.Attributes.Member)
+		group, err = l.resolveDynamicMembers(group)
+		if err != nil {
+			return http.StatusBadRequest, gin.H{
+				"ok":    false,
+				"error": err,
+			}
+		}
 		results = append(results, LDAPGroupToGin(group))
 	}
 
@@ -238,16 +423,17 @@ func (l LDAPClient) GetAllGroups() (int, gin.H) {
 	}
 }
 
-func (l LDAPClient) GetGroup(gid string) (int, gin.H) {
+func (l LDAPClient) GetGroup(gid string) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "GetGroup", time.Now(), &res)
 	searchRequest := ldap.NewSearchRequest( //  setup search for user by uid
 		fmt.Sprintf("cn=%s,%s", gid, l.groupsdn), // The base dn to search
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		"(&(objectClass=groupOfNames))", // The filter to apply
-		[]string{"cn", "member"},        // A list attributes to retrieve
+		"(|(objectClass=groupOfNames)(objectClass=groupOfURLs))", // The filter to apply
+		l.groupAttributeList(), // A list attributes to retrieve
 		nil,
 	)
 
-	searchResponse, err := l.client.Search(searchRequest) // perform search
+	searchResponse, err := l.search(searchRequest) // perform search
 	if err != nil {
 		return http.StatusBadRequest, gin.H{
 			"ok":    false,
@@ -257,6 +443,14 @@ func (l LDAPClient) GetGroup(gid string) (int, gin.H) {
 
 	entry := searchResponse.Entries[0]
 	group := LDAPEntryToLDAPGroup(entry)
+	group.Attributes.Member = l.hidePlaceholderMembers(group.DN, group.Attributes.Member)
+	group, err = l.resolveDynamicMembers(group)
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
 	result := LDAPGroupToGin(group)
 
 	return http.StatusOK, gin.H{
@@ -266,16 +460,46 @@ func (l LDAPClient) GetGroup(gid string) (int, gin.H) {
 	}
 }
 
-func (l LDAPClient) AddGroup(gid string, group Group) (int, gin.H) {
+func (l LDAPClient) AddGroup(gid string, group Group) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "AddGroup", time.Now(), &res)
+	groupDN := fmt.Sprintf("cn=%s,%s", gid, l.groupsdn)
+
 	addRequest := ldap.NewAddRequest(
-		fmt.Sprintf("cn=%s,%s", gid, l.groupsdn), // DN
-		nil,                                      // controls
+		groupDN, // DN
+		nil,     // controls
 	)
 	addRequest.Attribute("cn", []string{gid})
-	addRequest.Attribute("member", []string{""})
-	addRequest.Attribute("objectClass", []string{"groupOfNames"})
+	if len(group.MemberURL) > 0 { // dynamic group with members defined by filter
+		for _, memberURL := range group.MemberURL {
+			if _, err := ParseMemberURL(memberURL); err != nil {
+				return http.StatusBadRequest, gin.H{
+					"ok":    false,
+					"error": ldap.NewError(ldap.LDAPResultInvalidAttributeSyntax, err),
+				}
+			}
+		}
+		addRequest.Attribute("memberURL", group.MemberURL)
+		addRequest.Attribute("objectClass", []string{"groupOfURLs"})
+	} else { // static group with members managed by the API
+		addRequest.Attribute("member", []string{l.placeholderMember(groupDN)}) // groupOfNames requires at least one member
+		addRequest.Attribute("objectClass", []string{"groupOfNames"})
+	}
+	if group.Description != "" {
+		addRequest.Attribute("description", []string{group.Description})
+	}
+	if len(group.Owner) > 0 {
+		addRequest.Attribute("owner", group.Owner)
+	}
+	if len(group.BusinessCategory) > 0 {
+		addRequest.Attribute("businessCategory", group.BusinessCategory)
+	}
+	for _, attr := range l.options().GroupAttributes { // only write extra attributes which are allowed by the config
+		if values, ok := group.Attributes[attr]; ok && len(values) > 0 {
+			addRequest.Attribute(attr, values)
+		}
+	}
 
-	err := l.client.Add(addRequest)
+	err := l.add(addRequest)
 	if err != nil {
 		return http.StatusBadRequest, gin.H{
 			"ok":    false,
@@ -289,15 +513,47 @@ func (l LDAPClient) AddGroup(gid string, group Group) (int, gin.H) {
 	}
 }
 
-func (l LDAPClient) ModGroup(gid string, group Group) (int, gin.H) {
+func (l LDAPClient) ModGroup(gid string, group Group) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "ModGroup", time.Now(), &res)
 	modifyRequest := ldap.NewModifyRequest(
 		fmt.Sprintf("cn=%s,%s", gid, l.groupsdn),
 		nil,
 	)
 
 	modifyRequest.Replace("cn", []string{gid})
+	if len(group.MemberURL) > 0 { // only valid for dynamic groups
+		for _, memberURL := range group.MemberURL {
+			if _, err := ParseMemberURL(memberURL); err != nil {
+				return http.StatusBadRequest, gin.H{
+					"ok":    false,
+					"error": ldap.NewError(ldap.LDAPResultInvalidAttributeSyntax, err),
+				}
+			}
+		}
+		modifyRequest.Replace("memberURL", group.MemberURL)
+	}
+	if group.Description != "" {
+		modifyRequest.Replace("description", []string{group.Description})
+	}
+	if len(group.Owner) > 0 {
+		modifyRequest.Replace("owner", group.Owner)
+	}
+	if len(group.BusinessCategory) > 0 {
+		modifyRequest.Replace("businessCategory", group.BusinessCategory)
+	}
+	groupAttributes := l.options().GroupAttributes
+	for _, attr := range groupAttributes { // only write extra attributes which are allowed by the config
+		if values, ok := group.Attributes[attr]; ok && len(values) > 0 {
+			modifyRequest.Replace(attr, values)
+		}
+	}
+	for _, attr := range group.Clear {
+		if slices.Contains(clearableGroupAttributes, attr) || slices.Contains(groupAttributes, attr) {
+			modifyRequest.Replace(attr, []string{}) // replacing with no values deletes the attribute and unlike a delete does not fail with noSuchAttribute if it is not set
+		}
+	}
 
-	err := l.client.Modify(modifyRequest)
+	err := l.modify(modifyRequest)
 	if err != nil {
 		return http.StatusBadRequest, gin.H{
 			"ok":    false,
@@ -311,7 +567,8 @@ func (l LDAPClient) ModGroup(gid string, group Group) (int, gin.H) {
 	}
 }
 
-func (l LDAPClient) DelGroup(gid string) (int, gin.H) {
+func (l LDAPClient) DelGroup(gid string) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "DelGroup", time.Now(), &res)
 	groupDN := fmt.Sprintf("cn=%s,%s", gid, l.groupsdn)
 
 	// assumes that memberOf overlay will automatically update referenced memberOf attributes
@@ -321,7 +578,7 @@ func (l LDAPClient) DelGroup(gid string) (int, gin.H) {
 		nil,
 	)
 
-	err := l.client.Del(deleteGroupRequest) // delete group
+	err := l.del(deleteGroupRequest) // delete group
 	if err != nil {
 		return http.StatusBadRequest, gin.H{
 			"ok":    false,
@@ -335,18 +592,441 @@ func (l LDAPClient) DelGroup(gid string) (int, gin.H) {
 	}
 }
 
-func (l LDAPClient) AddUserToGroup(uid string, gid string) (int, gin.H) {
+func (l LDAPClient) AddUserToGroup(uid string, gid string) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "AddUserToGroup", time.Now(), &res)
+	userDN := fmt.Sprintf("uid=%s,%s", uid, l.peopledn)
+	groupDN := fmt.Sprintf("cn=%s,%s", gid, l.groupsdn)
+	return l.addGroupMember(userDN, groupDN)
+}
+
+func (l LDAPClient) DelUserFromGroup(uid string, gid string) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "DelUserFromGroup", time.Now(), &res)
+	userDN := fmt.Sprintf("uid=%s,%s", uid, l.peopledn)
+	groupDN := fmt.Sprintf("cn=%s,%s", gid, l.groupsdn)
+	return l.delGroupMember(userDN, groupDN)
+}
+
+func (l LDAPClient) SetGroupMembers(gid string, uids []string) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "SetGroupMembers", time.Now(), &res)
+	groupDN := fmt.Sprintf("cn=%s,%s", gid, l.groupsdn)
+
+	members, err := l.getGroupMembers(groupDN) // get current members to compute the difference
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+
+	users, err := l.getUserDNs(uids) // get the DNs of requested users which exist
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+
+	added := []string{}
+	removed := []string{}
+	notfound := []string{}
+	addDNs := []string{}
+	delDNs := []string{}
+
+	current := map[string]bool{}
+	remaining := 0 // number of real members which are not users such as nested groups
+	for _, member := range l.hidePlaceholderMembers(groupDN, members) {
+		if l.isGroupDN(member) {
+			remaining++
+			continue
+		}
+		current[strings.ToLower(member)] = true
+	}
+
+	desired := map[string]bool{}
+	for _, uid := range uids {
+		userDN, ok := users[strings.ToLower(uid)]
+		if !ok {
+			notfound = append(notfound, uid)
+			continue
+		}
+		key := strings.ToLower(userDN)
+		if desired[key] { // ignore duplicate uids
+			continue
+		}
+		desired[key] = true
+		if !current[key] {
+			added = append(added, uid)
+			addDNs = append(addDNs, userDN)
+		}
+	}
+
+	for _, member := range l.hidePlaceholderMembers(groupDN, members) {
+		if l.isGroupDN(member) || desired[strings.ToLower(member)] {
+			continue
+		}
+		removed = append(removed, getRDNValue(member))
+		delDNs = append(delDNs, member)
+	}
+
+	if len(addDNs) > 0 || len(delDNs) > 0 {
+		modifyRequest := ldap.NewModifyRequest( // modify group member value
+			groupDN,
+			nil,
+		)
+
+		if len(addDNs) > 0 {
+			modifyRequest.Add("member", addDNs)
+		}
+		if len(delDNs) > 0 {
+			modifyRequest.Delete("member", delDNs)
+		}
+		placeholders := l.getPlaceholderMembers(groupDN, members)
+		if remaining+len(desired) > 0 && len(placeholders) > 0 { // remove placeholders if the group will have real members
+			modifyRequest.Delete("member", placeholders)
+		} else if remaining+len(desired) == 0 && len(placeholders) == 0 { // add placeholder since groupOfNames requires at least one member
+			modifyRequest.Add("member", []string{l.placeholderMember(groupDN)})
+		}
+
+		err = l.modify(modifyRequest) // modify group
+		if err != nil {
+			return http.StatusBadRequest, gin.H{
+				"ok":    false,
+				"error": err,
+			}
+		}
+	}
+
+	return http.StatusOK, gin.H{
+		"ok":       true,
+		"error":    nil,
+		"added":    added,
+		"removed":  removed,
+		"notfound": notfound,
+	}
+}
+
+func (l LDAPClient) GetUserGroups(uid string) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "GetUserGroups", time.Now(), &res)
 	userDN := fmt.Sprintf("uid=%s,%s", uid, l.peopledn)
+
+	groups, err := l.getUserGroups(userDN)
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+
+	dynamicGroups, err := l.getUserDynamicGroups(userDN) // listed separately since they cannot be changed by SetUserGroups
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+
+	return http.StatusOK, gin.H{
+		"ok":            true,
+		"error":         nil,
+		"groups":        groups,
+		"dynamicGroups": dynamicGroups,
+	}
+}
+
+func (l LDAPClient) SetUserGroups(uid string, gids []string) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "SetUserGroups", time.Now(), &res)
+	userDN := fmt.Sprintf("uid=%s,%s", uid, l.peopledn)
+
+	current, err := l.getUserGroups(userDN) // get current groups to compute the difference
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+
+	added := []string{}
+	removed := []string{}
+	failed := []gin.H{}
+
+	currentSet := map[string]bool{}
+	for _, gid := range current {
+		currentSet[strings.ToLower(gid)] = true
+	}
+	desiredSet := map[string]bool{}
+	for _, gid := range gids {
+		key := strings.ToLower(gid)
+		if desiredSet[key] { // ignore duplicate gids
+			continue
+		}
+		desiredSet[key] = true
+		if currentSet[key] {
+			continue
+		}
+		status, res := l.AddUserToGroup(uid, gid)
+		if status != http.StatusOK {
+			failed = append(failed, gin.H{"group": gid, "error": LDAPErrorToGin(res["error"].(error))})
+			continue
+		}
+		added = append(added, gid)
+	}
+
+	for _, gid := range current {
+		if desiredSet[strings.ToLower(gid)] {
+			continue
+		}
+		status, res := l.DelUserFromGroup(uid, gid)
+		if status != http.StatusOK {
+			failed = append(failed, gin.H{"group": gid, "error": LDAPErrorToGin(res["error"].(error))})
+			continue
+		}
+		removed = append(removed, gid)
+	}
+
+	status = http.StatusOK
+	if len(failed) > 0 {
+		status = http.StatusMultiStatus
+	}
+
+	return status, gin.H{
+		"ok":      len(failed) == 0,
+		"error":   nil,
+		"added":   added,
+		"removed": removed,
+		"failed":  failed,
+	}
+}
+
+func (l LDAPClient) AddGroupToGroup(childgid string, gid string) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "AddGroupToGroup", time.Now(), &res)
+	childDN := fmt.Sprintf("cn=%s,%s", childgid, l.groupsdn)
+	groupDN := fmt.Sprintf("cn=%s,%s", gid, l.groupsdn)
+
+	// check that adding the child group does not create a cycle, which also checks that the child group exists
+	subgroups, err := l.getEffectiveSubgroups(childDN)
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+	if strings.EqualFold(childDN, groupDN) || subgroups[strings.ToLower(groupDN)] {
+		return http.StatusBadRequest, gin.H{
+			"ok": false,
+			"error": ldap.NewError(
+				ldap.LDAPResultLoopDetect,
+				fmt.Errorf("adding %s to %s would create a membership cycle", childDN, groupDN),
+			),
+		}
+	}
+
+	return l.addGroupMember(childDN, groupDN)
+}
+
+func (l LDAPClient) DelGroupFromGroup(childgid string, gid string) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "DelGroupFromGroup", time.Now(), &res)
+	childDN := fmt.Sprintf("cn=%s,%s", childgid, l.groupsdn)
 	groupDN := fmt.Sprintf("cn=%s,%s", gid, l.groupsdn)
+	return l.delGroupMember(childDN, groupDN)
+}
+
+// returns true if the error means the server does not support the in chain matching rule, any other error is returned rather than hidden by the iterative search
+func inChainUnsupported(err error) bool {
+	return ldap.IsErrorWithCode(err, ldap.LDAPResultInappropriateMatching) || ldap.IsErrorWithCode(err, ldap.LDAPResultUnavailableCriticalExtension)
+}
+
+// whether the in chain matching rule is listed by the subschema of each LDAP URL, servers such as OpenLDAP evaluate unknown rules as undefined and return no entries instead of an error
+var inChainSupport sync.Map
+
+// returns false if the subschema of the server does not list the in chain matching rule, the result is cached per LDAP URL and servers whose subschema cannot be read are assumed to support it
+func (l LDAPClient) inChainSupported() bool {
+	if supported, ok := inChainSupport.Load(l.url); ok {
+		return supported.(bool)
+	}
+	rootDSE, err := l.search(ldap.NewSearchRequest(
+		"", // The base dn to search
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)",             // The filter to apply
+		[]string{"subschemaSubentry"}, // A list attributes to retrieve
+		nil,
+	))
+	if err != nil || len(rootDSE.Entries) == 0 || rootDSE.Entries[0].GetAttributeValue("subschemaSubentry") == "" {
+		return true
+	}
+	subschema, err := l.search(ldap.NewSearchRequest(
+		rootDSE.Entries[0].GetAttributeValue("subschemaSubentry"), // The base dn to search
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=subschema)", // The filter to apply
+		[]string{"matchingRules"}, // A list attributes to retrieve
+		nil,
+	))
+	if err != nil || len(subschema.Entries) == 0 {
+		return true
+	}
+	supported := false
+	for _, rule := range subschema.Entries[0].GetAttributeValues("matchingRules") {
+		if strings.HasPrefix(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rule), "(")), LDAPMatchingRuleInChain+" ") {
+			supported = true
+			break
+		}
+	}
+	inChainSupport.Store(l.url, supported)
+	return supported
+}
+
+// returns the DNs of the entries matching the in chain filter if the server supports the rule, ok is false if the iterative search must be used instead
+func (l LDAPClient) searchInChain(basedn string, filter string) (dns []string, ok bool, err error) {
+	if !l.options().UseMatchingRuleInChain || !l.inChainSupported() {
+		return nil, false, nil
+	}
+	dns, err = l.searchDNs(basedn, filter)
+	if err != nil {
+		if inChainUnsupported(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return dns, len(dns) > 0, nil // an empty result may also mean the rule was evaluated as undefined, which the iterative search confirms
+}
+
+func (l LDAPClient) GetUserEffectiveGroups(uid string) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "GetUserEffectiveGroups", time.Now(), &res)
+	userDN := fmt.Sprintf("uid=%s,%s", uid, l.peopledn)
+
+	users, err := l.searchDNs(userDN, "(objectClass=inetOrgPerson)") // unknown users are an error rather than a user without groups
+	if err == nil && len(users) == 0 {
+		err = ldap.NewError(ldap.LDAPResultNoSuchObject, fmt.Errorf("user %s does not exist", uid))
+	}
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+
+	// try the in chain matching rule first, falling back to iterative search if it is not supported
+	groups, ok, err := l.searchInChain(l.groupsdn, fmt.Sprintf("(&(objectClass=groupOfNames)(member:%s:=%s))", LDAPMatchingRuleInChain, ldap.EscapeFilter(userDN)))
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+	if ok {
+		return http.StatusOK, gin.H{
+			"ok":     true,
+			"error":  nil,
+			"groups": groups,
+		}
+	}
+
+	groups = []string{}
+	visited := map[string]bool{}
+	queue := []string{userDN}
+	for len(queue) > 0 { // breadth first search up the membership graph
+		memberDN := queue[0]
+		queue = queue[1:]
+		parents, err := l.searchDNs(l.groupsdn, fmt.Sprintf("(&(objectClass=groupOfNames)(member=%s))", ldap.EscapeFilter(memberDN)))
+		if err != nil {
+			return http.StatusBadRequest, gin.H{
+				"ok":    false,
+				"error": err,
+			}
+		}
+		for _, parentDN := range parents {
+			if visited[strings.ToLower(parentDN)] {
+				continue
+			}
+			visited[strings.ToLower(parentDN)] = true
+			groups = append(groups, parentDN)
+			queue = append(queue, parentDN)
+		}
+	}
+
+	return http.StatusOK, gin.H{
+		"ok":     true,
+		"error":  nil,
+		"groups": groups,
+	}
+}
+
+func (l LDAPClient) GetGroupEffectiveMembers(gid string) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "GetGroupEffectiveMembers", time.Now(), &res)
+	groupDN := fmt.Sprintf("cn=%s,%s", gid, l.groupsdn)
+
+	// try the in chain matching rule first, falling back to iterative search if it is not supported
+	members, ok, err := l.searchInChain(l.peopledn, fmt.Sprintf("(&(objectClass=inetOrgPerson)(memberOf:%s:=%s))", LDAPMatchingRuleInChain, ldap.EscapeFilter(groupDN)))
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+	if ok {
+		return http.StatusOK, gin.H{
+			"ok":      true,
+			"error":   nil,
+			"members": members,
+		}
+	}
+
+	members = []string{}
+	visitedMembers := map[string]bool{}
+	visitedGroups := map[string]bool{strings.ToLower(groupDN): true}
+	queue := []string{groupDN}
+	for len(queue) > 0 { // breadth first search down the membership graph
+		currentDN := queue[0]
+		queue = queue[1:]
+		values, err := l.getGroupMembers(currentDN)
+		if err != nil {
+			return http.StatusBadRequest, gin.H{
+				"ok":    false,
+				"error": err,
+			}
+		}
+		for _, memberDN := range l.hidePlaceholderMembers(currentDN, values) {
+			key := strings.ToLower(memberDN)
+			if l.isGroupDN(memberDN) {
+				if !visitedGroups[key] {
+					visitedGroups[key] = true
+					queue = append(queue, memberDN)
+				}
+			} else if !visitedMembers[key] {
+				visitedMembers[key] = true
+				members = append(members, memberDN)
+			}
+		}
+	}
+
+	return http.StatusOK, gin.H{
+		"ok":      true,
+		"error":   nil,
+		"members": members,
+	}
+}
+
+// adds a member DN to a group, removing any placeholders
+func (l LDAPClient) addGroupMember(memberDN string, groupDN string) (int, gin.H) {
+	members, err := l.getGroupMembers(groupDN) // get current members to check for placeholders
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
 
 	modifyRequest := ldap.NewModifyRequest( // modify group member value
 		groupDN,
 		nil,
 	)
 
-	modifyRequest.Add("member", []string{userDN}) // add user to group member attribute
+	modifyRequest.Add("member", []string{memberDN}) // add member to group member attribute
+	placeholders := l.getPlaceholderMembers(groupDN, members)
+	if len(placeholders) > 0 { // remove placeholders now that the group has a real member
+		modifyRequest.Delete("member", placeholders)
+	}
 
-	err := l.client.Modify(modifyRequest) // modify group
+	err = l.modify(modifyRequest) // modify group
 	if err != nil {
 		return http.StatusBadRequest, gin.H{
 			"ok":    false,
@@ -360,18 +1040,33 @@ func (l LDAPClient) AddUserToGroup(uid string, gid string) (int, gin.H) {
 	}
 }
 
-func (l LDAPClient) DelUserFromGroup(uid string, gid string) (int, gin.H) {
-	userDN := fmt.Sprintf("uid=%s,%s", uid, l.peopledn)
-	groupDN := fmt.Sprintf("cn=%s,%s", gid, l.groupsdn)
+// removes a member DN from a group, adding a placeholder if the group would be empty
+func (l LDAPClient) delGroupMember(memberDN string, groupDN string) (int, gin.H) {
+	members, err := l.getGroupMembers(groupDN) // get current members to check if the group will be empty
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
 
 	modifyRequest := ldap.NewModifyRequest( // modify group member value
 		groupDN,
 		nil,
 	)
 
-	modifyRequest.Delete("member", []string{userDN}) // remove user from group member attribute
+	remaining := 0
+	for _, member := range l.hidePlaceholderMembers(groupDN, members) {
+		if !strings.EqualFold(member, memberDN) {
+			remaining++
+		}
+	}
+	if remaining == 0 && len(l.getPlaceholderMembers(groupDN, members)) == 0 { // add placeholder since groupOfNames requires at least one member
+		modifyRequest.Add("member", []string{l.placeholderMember(groupDN)})
+	}
+	modifyRequest.Delete("member", []string{memberDN}) // remove member from group member attribute
 
-	err := l.client.Modify(modifyRequest) // modify group
+	err = l.modify(modifyRequest) // modify group
 	if err != nil {
 		return http.StatusBadRequest, gin.H{
 			"ok":    false,
@@ -384,3 +1079,395 @@ func (l LDAPClient) DelUserFromGroup(uid string, gid string) (int, gin.H) {
 		"error": nil,
 	}
 }
+
+// returns the set of lowercase DNs of all groups nested under a group
+func (l LDAPClient) getEffectiveSubgroups(groupDN string) (map[string]bool, error) {
+	subgroups := map[string]bool{}
+	queue := []string{groupDN}
+	for len(queue) > 0 {
+		currentDN := queue[0]
+		queue = queue[1:]
+		members, err := l.getGroupMembers(currentDN)
+		if err != nil {
+			return nil, err
+		}
+		for _, memberDN := range l.hidePlaceholderMembers(currentDN, members) {
+			key := strings.ToLower(memberDN)
+			if l.isGroupDN(memberDN) && !subgroups[key] {
+				subgroups[key] = true
+				queue = append(queue, memberDN)
+			}
+		}
+	}
+	return subgroups, nil
+}
+
+// returns the cn of each group which directly contains the user, checking that the user exists
+func (l LDAPClient) getUserGroups(userDN string) ([]string, error) {
+	searchRequest := ldap.NewSearchRequest( //  check that the user exists
+		userDN, // The base dn to search
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(&(objectClass=inetOrgPerson))", // The filter to apply
+		[]string{"dn"},                   // A list attributes to retrieve
+		nil,
+	)
+
+	_, err := l.search(searchRequest) // perform search
+	if err != nil {
+		return nil, err
+	}
+
+	searchRequest = ldap.NewSearchRequest( // search for groups with the user as a member
+		l.groupsdn, // The base dn to search
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf("(&(objectClass=groupOfNames)(member=%s))", ldap.EscapeFilter(userDN)), // The filter to apply
+		[]string{"cn"}, // A list attributes to retrieve
+		nil,
+	)
+
+	searchResponse, err := l.search(searchRequest) // perform search
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []string{}
+	for _, entry := range searchResponse.Entries {
+		groups = append(groups, entry.GetAttributeValue("cn"))
+	}
+	return groups, nil
+}
+
+// returns a map of lowercase uid to user DN for each of the uids which exist
+func (l LDAPClient) getUserDNs(uids []string) (map[string]string, error) {
+	users := map[string]string{}
+	if len(uids) == 0 {
+		return users, nil
+	}
+
+	filter := ""
+	for _, uid := range uids {
+		filter += fmt.Sprintf("(uid=%s)", ldap.EscapeFilter(uid))
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		l.peopledn, // The base dn to search
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf("(&(objectClass=inetOrgPerson)(|%s))", filter), // The filter to apply
+		[]string{"uid"}, // A list attributes to retrieve
+		nil,
+	)
+
+	searchResponse, err := l.search(searchRequest) // perform search
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range searchResponse.Entries {
+		users[strings.ToLower(entry.GetAttributeValue("uid"))] = entry.DN
+	}
+	return users, nil
+}
+
+// returns true if the DN is an entry under the groups DN
+func (l LDAPClient) isGroupDN(dn string) bool {
+	return strings.HasSuffix(strings.ToLower(dn), ","+strings.ToLower(l.groupsdn))
+}
+
+// returns the DNs of all entries under the base DN matching the filter
+func (l LDAPClient) searchDNs(basedn string, filter string) ([]string, error) {
+	searchRequest := ldap.NewSearchRequest(
+		basedn, // The base dn to search
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,         // The filter to apply
+		[]string{"dn"}, // A list attributes to retrieve
+		nil,
+	)
+
+	searchResponse, err := l.search(searchRequest) // perform search
+	if err != nil {
+		return nil, err
+	}
+
+	results := []string{}
+	for _, entry := range searchResponse.Entries {
+		results = append(results, entry.DN)
+	}
+	return results, nil
+}
+
+// returns the list of user attributes to retrieve including the account expiry attribute from the config
+func (l LDAPClient) userAttributeList() []string {
+	expiryattr := l.expiryAttribute()
+	attributes := []string{"dn", "cn", "sn", "mail", "uid", "memberOf"}
+	if expiryattr != "" {
+		attributes = append(attributes, expiryattr)
+	}
+	return attributes
+}
+
+// returns the account expiry of the entry, or nil if account expiry is not enabled or the entry does not expire
+func (l LDAPClient) getExpiry(entry *ldap.Entry) *time.Time {
+	expiryattr := l.expiryAttribute()
+	if expiryattr == "" {
+		return nil
+	}
+	expiresAt, err := ParseExpiry(expiryattr, entry.GetAttributeValue(expiryattr))
+	if err != nil {
+		return nil
+	}
+	return expiresAt
+}
+
+// returns all user entries which have an account expiry
+func (l LDAPClient) getUsersWithExpiry() ([]*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		l.peopledn, // The base dn to search
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf("(&(objectClass=inetOrgPerson)(%s=*))", l.expiryAttribute()), // The filter to apply
+		append(l.userAttributeList(), "pwdAccountLockedTime"),                    // A list attributes to retrieve
+		nil,
+	)
+
+	searchResponse, err := l.search(searchRequest) // perform search
+	if err != nil {
+		return nil, err
+	}
+	return searchResponse.Entries, nil
+}
+
+// returns the set of lowercase objectClass values of an entry
+func (l LDAPClient) getObjectClasses(dn string) (map[string]bool, error) {
+	searchRequest := ldap.NewSearchRequest(
+		dn, // The base dn to search
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)",       // The filter to apply
+		[]string{"objectClass"}, // A list attributes to retrieve
+		nil,
+	)
+
+	searchResponse, err := l.search(searchRequest) // perform search
+	if err != nil {
+		return nil, err
+	}
+
+	objectClasses := map[string]bool{}
+	for _, objectClass := range searchResponse.Entries[0].GetAttributeValues("objectClass") {
+		objectClasses[strings.ToLower(objectClass)] = true
+	}
+	return objectClasses, nil
+}
+
+// group attributes written by the API itself, which cannot be configured as extra attributes
+var reservedGroupAttributes = []string{"objectClass", "cn", "member", "memberURL", "description", "owner", "businessCategory"}
+
+// returns the list of group attributes to retrieve including any extra attributes from the config
+func (l LDAPClient) groupAttributeList() []string {
+	attributes := []string{"cn", "member", "memberURL", "description", "owner", "businessCategory"}
+	return append(attributes, l.options().GroupAttributes...)
+}
+
+// returns the raw member values of a group including any placeholders
+func (l LDAPClient) getGroupMembers(groupDN string) ([]string, error) {
+	searchRequest := ldap.NewSearchRequest(
+		groupDN, // The base dn to search
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(&(objectClass=groupOfNames))", // The filter to apply
+		[]string{"member"},              // A list attributes to retrieve
+		nil,
+	)
+
+	searchResponse, err := l.search(searchRequest) // perform search
+	if err != nil {
+		return nil, err
+	}
+	if len(searchResponse.Entries) == 0 { // entry exists but is not a static group such as a dynamic group
+		return nil, ldap.NewError(
+			ldap.LDAPResultUnwillingToPerform,
+			fmt.Errorf("%s is not a groupOfNames group", groupDN),
+		)
+	}
+
+	return searchResponse.Entries[0].GetAttributeValues("member"), nil
+}
+
+// returns the group with members resolved from its memberURL values, static groups are returned unchanged
+func (l LDAPClient) resolveDynamicMembers(group LDAPGroup) (LDAPGroup, error) {
+	if len(group.Attributes.MemberURL) == 0 {
+		return group, nil
+	}
+
+	members := []string{}
+	seen := map[string]bool{}
+	for _, memberURL := range group.Attributes.MemberURL {
+		parsed, err := ParseMemberURL(memberURL)
+		if err != nil {
+			return group, ldap.NewError(ldap.LDAPResultInvalidAttributeSyntax, err)
+		}
+		if parsed.BaseDN == "" { // default to the base dn when the url does not specify one
+			parsed.BaseDN = l.basedn
+		}
+
+		searchRequest := ldap.NewSearchRequest(
+			parsed.BaseDN, // The base dn to search
+			parsed.Scope, ldap.NeverDerefAliases, 0, 0, false,
+			parsed.Filter,  // The filter to apply
+			[]string{"dn"}, // A list attributes to retrieve
+			nil,
+		)
+
+		searchResponse, err := l.search(searchRequest) // perform search
+		if err != nil {
+			return group, err
+		}
+
+		for _, entry := range searchResponse.Entries {
+			if !seen[strings.ToLower(entry.DN)] {
+				seen[strings.ToLower(entry.DN)] = true
+				members = append(members, entry.DN)
+			}
+		}
+	}
+
+	group.Attributes.Member = members
+	return group, nil
+}
+
+// returns the cn of each dynamic group with a memberURL which matches the user
+func (l LDAPClient) getUserDynamicGroups(userDN string) ([]string, error) {
+	searchRequest := ldap.NewSearchRequest(
+		l.groupsdn, // The base dn to search
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=groupOfURLs)", // The filter to apply
+		[]string{"cn", "memberURL"}, // A list attributes to retrieve
+		nil,
+	)
+
+	searchResponse, err := l.search(searchRequest) // perform search
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []string{}
+	for _, entry := range searchResponse.Entries {
+		for _, memberURL := range entry.GetAttributeValues("memberURL") {
+			matched, err := l.matchesMemberURL(userDN, memberURL)
+			if err != nil {
+				return nil, err
+			}
+			if matched {
+				groups = append(groups, entry.GetAttributeValue("cn"))
+				break
+			}
+		}
+	}
+	return groups, nil
+}
+
+// returns true if the entry is within the base and scope of the memberURL and matches its filter
+func (l LDAPClient) matchesMemberURL(entryDN string, memberURL string) (bool, error) {
+	parsed, err := ParseMemberURL(memberURL)
+	if err != nil {
+		return false, ldap.NewError(ldap.LDAPResultInvalidAttributeSyntax, err)
+	}
+	if parsed.BaseDN == "" { // default to the base dn when the url does not specify one
+		parsed.BaseDN = l.basedn
+	}
+	base, err := ldap.ParseDN(parsed.BaseDN)
+	if err != nil {
+		return false, ldap.NewError(ldap.LDAPResultInvalidDNSyntax, err)
+	}
+	entry, err := ldap.ParseDN(entryDN)
+	if err != nil {
+		return false, ldap.NewError(ldap.LDAPResultInvalidDNSyntax, err)
+	}
+
+	switch parsed.Scope {
+	case ldap.ScopeBaseObject:
+		if !base.EqualFold(entry) {
+			return false, nil
+		}
+	case ldap.ScopeSingleLevel:
+		if len(entry.RDNs) == 0 || !base.EqualFold(&ldap.DN{RDNs: entry.RDNs[1:]}) {
+			return false, nil
+		}
+	default:
+		if !base.EqualFold(entry) && !base.AncestorOfFold(entry) {
+			return false, nil
+		}
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		entryDN, // The base dn to search
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		parsed.Filter,  // The filter to apply
+		[]string{"dn"}, // A list attributes to retrieve
+		nil,
+	)
+
+	searchResponse, err := l.search(searchRequest) // perform search
+	if err != nil {
+		return false, err
+	}
+	return len(searchResponse.Entries) > 0, nil
+}
+
+// returns the placeholder member DN used to keep a group non-empty, defaults to the group's own DN
+func (l LDAPClient) placeholderMember(groupDN string) string {
+	placeholder := l.options().GroupPlaceholderMember
+	if placeholder == "" {
+		return groupDN
+	}
+	return placeholder
+}
+
+// returns true if the member value is a placeholder, including the empty value written by older versions
+func (l LDAPClient) isPlaceholderMember(groupDN string, member string) bool {
+	return member == "" || strings.EqualFold(member, l.placeholderMember(groupDN))
+}
+
+// returns the placeholder values in the list of members
+func (l LDAPClient) getPlaceholderMembers(groupDN string, members []string) []string {
+	placeholders := []string{}
+	for _, member := range members {
+		if l.isPlaceholderMember(groupDN, member) {
+			placeholders = append(placeholders, member)
+		}
+	}
+	return placeholders
+}
+
+// returns the list of members without any placeholders
+func (l LDAPClient) hidePlaceholderMembers(groupDN string, members []string) []string {
+	result := []string{}
+	for _, member := range members {
+		if !l.isPlaceholderMember(groupDN, member) {
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+// returns http.StatusOK if the bound user is a member of the admin group, otherwise an error response
+func (l LDAPClient) checkAdmin(adminGroup string) (int, gin.H) {
+	if adminGroup == "" {
+		return http.StatusForbidden, gin.H{
+			"ok":    false,
+			"error": ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("admin group is not configured")),
+		}
+	}
+
+	groups, err := l.getUserGroups(l.binddn)
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+	if !slices.ContainsFunc(groups, func(group string) bool { return strings.EqualFold(group, adminGroup) }) {
+		return http.StatusForbidden, gin.H{
+			"ok":    false,
+			"error": ldap.NewError(ldap.LDAPResultInsufficientAccessRights, fmt.Errorf("%s is not a member of %s", l.binddn, adminGroup)),
+		}
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,193 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// key of the request ID in the gin context
+const RequestIDKey = "requestID"
+
+// header used to accept and return the request ID
+const RequestIDHeader = "X-Request-ID"
+
+// attributes which are always redacted from logs regardless of case
+var redactedAttributes = map[string]bool{
+	"password":     true,
+	"userpassword": true,
+}
+
+// returns the attribute with its value replaced if the key is a redacted attribute, used as the ReplaceAttr of the slog handler
+func RedactAttr(groups []string, a slog.Attr) slog.Attr {
+	if redactedAttributes[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, "[REDACTED]")
+	}
+	return a
+}
+
+// returns the raw query with the values of redacted attributes replaced
+func RedactQuery(rawQuery string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return ""
+	}
+	for key := range values {
+		if redactedAttributes[strings.ToLower(key)] {
+			values[key] = []string{"[REDACTED]"}
+		}
+	}
+	return values.Encode()
+}
+
+// returns a new slog logger writing to w with the level (debug, info, warn, error) and format (json, text)
+func NewLogger(w io.Writer, level string, format string) (*slog.Logger, error) {
+	var logLevel slog.Level
+	if level == "" {
+		level = "info"
+	}
+	err := logLevel.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, err
+	}
+
+	options := &slog.HandlerOptions{
+		Level:       logLevel,
+		ReplaceAttr: RedactAttr,
+	}
+	switch format {
+	case "", "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %s", format)
+	}
+}
+
+// log the error and exit, used for errors during startup
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// gin middleware which sets the request ID from the X-Request-ID header or generates a new one, and returns it in the response header and in the body of json error responses
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" || len(requestID) > 128 {
+			id, _ := uuid.NewV4()
+			requestID = id.String()
+		}
+		c.Set(RequestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		writer := &requestIDWriter{ResponseWriter: c.Writer, requestID: requestID}
+		c.Writer = writer
+		c.Next()
+		writer.flush()
+	}
+}
+
+// response writer which holds back json error bodies so that the request ID can be added to them
+type requestIDWriter struct {
+	gin.ResponseWriter
+	requestID string
+	body      bytes.Buffer
+}
+
+// returns true if the response is a json error, the status and content type are always set before the body is written
+func (w *requestIDWriter) buffering() bool {
+	return w.ResponseWriter.Status() >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json")
+}
+
+func (w *requestIDWriter) Write(data []byte) (int, error) {
+	if w.buffering() {
+		return w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *requestIDWriter) WriteString(data string) (int, error) {
+	if w.buffering() {
+		return w.body.WriteString(data)
+	}
+	return w.ResponseWriter.WriteString(data)
+}
+
+// write the held back body with the request ID added, bodies which are not json objects are written unchanged
+func (w *requestIDWriter) flush() {
+	if w.body.Len() == 0 {
+		return
+	}
+	content := w.body.Bytes()
+	var body map[string]any
+	if json.Unmarshal(content, &body) == nil && body != nil {
+		if _, ok := body[RequestIDKey]; !ok {
+			body[RequestIDKey] = w.requestID
+			if marshalled, err := json.Marshal(body); err == nil {
+				content = marshalled
+			}
+		}
+	}
+	_, _ = w.ResponseWriter.Write(content)
+}
+
+// gin middleware which logs each request with its request ID, replaces the default gin logger
+func LoggerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		} else if c.Writer.Status() >= 400 {
+			level = slog.LevelWarn
+		} else if c.Request.UserAgent() == healthCheckUserAgent {
+			level = slog.LevelDebug
+		}
+		attrs := []any{
+			RequestIDKey, c.GetString(RequestIDKey),
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"query", RedactQuery(c.Request.URL.RawQuery),
+			"status", c.Writer.Status(),
+			"duration", time.Since(start),
+			"clientIP", c.ClientIP(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+		slog.Log(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// returns a copy of the session which logs with the request ID and traces with the context of the gin request, or nil if the session is nil
+func (l *LDAPClient) WithRequest(c *gin.Context) *LDAPClient {
+	if l == nil {
+		return nil
+	}
+	client := *l
+	client.requestid = c.GetString(RequestIDKey)
+	client.ctx = c.Request.Context()
+	return &client
+}
+
+// gin middleware which logs panics with the request ID instead of writing the stack to stderr
+func RecoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		slog.Error("Recovered from panic", RequestIDKey, c.GetString(RequestIDKey), "error", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error", RequestIDKey: c.GetString(RequestIDKey)})
+	})
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// registry of the metrics served at /metrics, only the metrics of the API are registered
+var MetricsRegistry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = promauto.With(MetricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "paasldap_http_requests_total",
+		Help: "Total HTTP requests by method, route, and status.",
+	}, []string{"method", "route", "status"})
+	HTTPRequestDuration = promauto.With(MetricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name: "paasldap_http_request_duration_seconds",
+		Help: "HTTP request latency by method and route.",
+	}, []string{"method", "route"})
+	LDAPOperations = promauto.With(MetricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "paasldap_ldap_operations_total",
+		Help: "Total LDAP operations by LDAPClient method and result code.",
+	}, []string{"method", "code", "result"})
+	LDAPOperationLatency = promauto.With(MetricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name: "paasldap_ldap_operation_duration_seconds",
+		Help: "LDAP operation latency by LDAPClient method.",
+	}, []string{"method"})
+	ActiveSessions = promauto.With(MetricsRegistry).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "paasldap_active_sessions",
+		Help: "Number of active LDAP sessions.",
+	}, func() float64 { return float64(SessionCount()) })
+	Logins = promauto.With(MetricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "paasldap_logins_total",
+		Help: "Total login attempts by result.",
+	}, []string{"result"})
+	LDAPDialErrors = promauto.With(MetricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "paasldap_ldap_dial_errors_total",
+		Help: "Total errors when dialing the LDAP server.",
+	})
+	ConfigReloads = promauto.With(MetricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "paasldap_config_reloads_total",
+		Help: "Total config reloads by result.",
+	}, []string{"result"})
+)
+
+// gin middleware which records the count and latency of each request by its route template
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" { // do not create a series for every unmatched path
+			route = "unmatched"
+		}
+		HTTPRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		HTTPRequestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// record the result code and latency of an LDAPClient method and log it with the request ID,
+// deferred with a pointer to the named result of the method so that the result code is read after the method returns
+func observeLDAPOperation(requestID string, method string, start time.Time, res *gin.H) {
+	duration := time.Since(start)
+	code := uint16(ldap.LDAPResultSuccess)
+	if *res != nil {
+		if err, ok := (*res)["error"].(*ldap.Error); ok && err != nil {
+			code = err.ResultCode
+		}
+	}
+	LDAPOperations.WithLabelValues(method, strconv.Itoa(int(code)), ldap.LDAPResultCodeMap[code]).Inc()
+	LDAPOperationLatency.WithLabelValues(method).Observe(duration.Seconds())
+
+	level := slog.LevelDebug
+	if code != ldap.LDAPResultSuccess {
+		level = slog.LevelWarn
+	}
+	slog.Log(context.Background(), level, "ldap operation", RequestIDKey, requestID, "method", method, "duration", duration, "code", code, "result", ldap.LDAPResultCodeMap[code])
+}
+
+// returns the handler serving every metric of MetricsRegistry in the prometheus exposition format
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(MetricsRegistry, promhttp.HandlerOpts{})
+}
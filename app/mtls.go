@@ -0,0 +1,189 @@
+package app
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// OID of the proxied authorization control described in https://tools.ietf.org/html/rfc4370
+const ControlTypeProxiedAuthorization = "2.16.840.1.113730.3.4.18"
+
+// prefix of the LDAPSessions key of client certificate identities
+const clientCertSessionPrefix = "mtls:"
+
+// ClientCertMapping maps a field of a client certificate to a uid
+type ClientCertMapping struct {
+	Field string `json:"field"` // subject.cn, subject, san.dns, san.email, or san.uri
+	Match string `json:"match"` // regular expression which must match the whole value, defaults to (.+)
+	UID   string `json:"uid"`   // uid template using the groups of match, defaults to $1
+}
+
+var clientCertFields = []string{"subject.cn", "subject", "san.dns", "san.email", "san.uri"}
+
+// characters which are not allowed in a mapped uid since it is used in a DN
+const invalidUIDCharacters = ",=+<>#;\\\""
+
+// returns the values of the certificate field
+func clientCertValues(cert *x509.Certificate, field string) []string {
+	switch field {
+	case "subject.cn":
+		return []string{cert.Subject.CommonName}
+	case "subject":
+		return []string{cert.Subject.String()}
+	case "san.dns":
+		return cert.DNSNames
+	case "san.email":
+		return cert.EmailAddresses
+	case "san.uri":
+		values := []string{}
+		for _, uri := range cert.URIs {
+			values = append(values, uri.String())
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// returns the regular expression of the mapping anchored to match the whole value
+func (m ClientCertMapping) regexp() (*regexp.Regexp, error) {
+	match := m.Match
+	if match == "" {
+		match = "(.+)"
+	}
+	return regexp.Compile("^(?:" + match + ")$")
+}
+
+// returns the uid of the first mapping which matches a value of the certificate
+func MapClientCertificate(cert *x509.Certificate, mappings []ClientCertMapping) (string, bool) {
+	for _, mapping := range mappings {
+		re, err := mapping.regexp()
+		if err != nil {
+			continue
+		}
+		template := mapping.UID
+		if template == "" {
+			template = "$1"
+		}
+		for _, value := range clientCertValues(cert, mapping.Field) {
+			match := re.FindStringSubmatchIndex(value)
+			if match == nil {
+				continue
+			}
+			uid := string(re.ExpandString(nil, template, value, match))
+			if uid != "" && !strings.ContainsAny(uid, invalidUIDCharacters) {
+				return uid, true
+			}
+		}
+	}
+	return "", false
+}
+
+// returns the proxied authorization control which performs an operation as the authorization identity ie. dn:uid=user,ou=people,dc=domain,dc=net
+func NewControlProxiedAuthorization(authzid string) ldap.Control {
+	return ldap.NewControlString(ControlTypeProxiedAuthorization, true, authzid)
+}
+
+// returns a new LDAPClient bound as the service account which performs every operation as the user with proxied authorization, used by client certificate and restored sessions
+func NewProxiedLDAPClient(config Config, uid string) (*LDAPClient, error) {
+	client, err := NewServiceLDAPClient(config)
+	if err != nil {
+		return nil, err
+	}
+	client.binddn = fmt.Sprintf("uid=%s,%s", uid, client.peopledn)
+	client.authzid = "dn:" + client.binddn
+	return client, nil
+}
+
+// returns an error if the proxied user does not exist or has expired
+func (l LDAPClient) checkProxiedUser(uid string) error {
+	searchRequest := ldap.NewSearchRequest(
+		l.binddn, // The base dn to search
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(&(objectClass=inetOrgPerson))", // The filter to apply
+		[]string{"uid"},                  // A list attributes to retrieve
+		nil,
+	)
+	if _, err := l.search(searchRequest); err != nil {
+		return err
+	}
+	expired, err := l.IsUserExpired(uid, time.Now())
+	if err != nil {
+		return err
+	}
+	if expired {
+		return errors.New("account has expired")
+	}
+	return nil
+}
+
+// returns the pool of CA certificates read from the PEM file
+func loadCertPool(path string) (*x509.CertPool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// gin middleware which uses the LDAP identity mapped from a verified client certificate as the session of requests without a ticket
+func ClientCertMiddleware(config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+			c.Next()
+			return
+		}
+		session := sessions.Default(c)
+		if session.Get("SessionUUID") != nil { // an existing ticket takes precedence
+			c.Next()
+			return
+		}
+
+		cert := c.Request.TLS.VerifiedChains[0][0]
+		uid, ok := MapClientCertificate(cert, config.TLS.ClientAuth.Mappings)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"auth": false, "error": "client certificate does not map to an LDAP identity"})
+			return
+		}
+
+		key := clientCertSessionPrefix + uid
+		client := GetSession(key)
+		if client == nil || client.client.IsClosing() { // connections are shared by every request with the same identity and dialed again once dropped
+			next, err := NewProxiedLDAPClient(currentConfig(config), uid)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"auth": false, "error": err.Error()})
+				return
+			}
+			registered := SwapSession(key, client, next)
+			if registered != next { // a concurrent request reconnected first
+				next.Close()
+			} else if client != nil {
+				client.Close()
+			}
+			client = registered
+		}
+		if err := client.WithRequest(c).checkProxiedUser(uid); err != nil { // users which were deleted or expired lose access immediately
+			slog.Warn("Rejected client certificate", RequestIDKey, c.GetString(RequestIDKey), "subject", cert.Subject.String(), "uid", uid, "error", err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"auth": false, "error": "client certificate identity is not a valid user"})
+			return
+		}
+
+		session.Set("SessionUUID", key) // not saved, so no ticket cookie is issued
+		c.Next()
+	}
+}
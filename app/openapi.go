@@ -0,0 +1,385 @@
+package app
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// OpenAPISchema with the subset of keywords used by openapi.json, additional properties are always allowed
+type OpenAPISchema struct {
+	Ref        string                    `json:"$ref"`
+	Type       string                    `json:"type"`
+	Format     string                    `json:"format"`
+	Enum       []string                  `json:"enum"`
+	Minimum    *float64                  `json:"minimum"`
+	Nullable   bool                      `json:"nullable"`
+	AllOf      []*OpenAPISchema          `json:"allOf"`
+	Items      *OpenAPISchema            `json:"items"`
+	Properties map[string]*OpenAPISchema `json:"properties"`
+	Required   []string                  `json:"required"`
+}
+
+// OpenAPIResponse of an operation or a reference to one of the components
+type OpenAPIResponse struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema *OpenAPISchema `json:"schema"`
+	} `json:"content"`
+}
+
+type OpenAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required"`
+	Schema   *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIOperation struct {
+	Parameters  []OpenAPIParameter `json:"parameters"`
+	RequestBody *struct {
+		Required bool `json:"required"`
+		Content  map[string]struct {
+			Schema *OpenAPISchema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]*OpenAPIResponse `json:"responses"`
+	document  *OpenAPI                    // used to resolve references
+}
+
+// OpenAPI document with the paths used for validation
+type OpenAPI struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Version string `json:"version"`
+	} `json:"info"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components struct {
+		Schemas   map[string]*OpenAPISchema   `json:"schemas"`
+		Responses map[string]*OpenAPIResponse `json:"responses"`
+	} `json:"components"`
+}
+
+// returns the parsed OpenAPI document served at /openapi.json
+func LoadOpenAPI() (*OpenAPI, error) {
+	var document OpenAPI
+	err := json.Unmarshal(openAPIDocument, &document)
+	if err != nil {
+		return nil, err
+	}
+	for _, operations := range document.Paths {
+		for _, op := range operations {
+			op.document = &document
+		}
+	}
+	return &document, nil
+}
+
+// returns the component a reference ie. #/components/schemas/User points to, or nil if it does not exist
+func resolveRef[T any](components map[string]*T, ref string, prefix string) *T {
+	name, ok := strings.CutPrefix(ref, prefix)
+	if !ok {
+		return nil
+	}
+	return components[name]
+}
+
+// returns the OpenAPI path of a gin route ie. /users/:userid -> /users/{userid}
+func OpenAPIPath(route string) string {
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// returns the operation of the gin method and route, or nil if it is not described
+func (o *OpenAPI) Operation(method string, route string) *OpenAPIOperation {
+	operations, ok := o.Paths[OpenAPIPath(route)]
+	if !ok {
+		return nil
+	}
+	return operations[strings.ToLower(method)]
+}
+
+// returns an error if the value does not match the scalar schema
+func validateValue(name string, schema *OpenAPISchema, value string) error {
+	if schema == nil {
+		return nil
+	}
+	switch schema.Type {
+	case "integer":
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s must be an integer", name)
+		}
+		if schema.Minimum != nil && float64(number) < *schema.Minimum {
+			return fmt.Errorf("%s must be at least %v", name, *schema.Minimum)
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s must be a boolean", name)
+		}
+	case "string":
+		if schema.Format == "date-time" && value != "" {
+			if _, err := time.Parse(time.RFC3339, value); err != nil {
+				return fmt.Errorf("%s must be an RFC 3339 date-time", name)
+			}
+		}
+	}
+	if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, value) {
+		return fmt.Errorf("%s must be one of %s", name, strings.Join(schema.Enum, ", "))
+	}
+	return nil
+}
+
+// returns an error if the values of a parameter or form field do not match the schema
+func validateValues(name string, schema *OpenAPISchema, values []string) error {
+	if schema != nil && schema.Type == "array" {
+		for _, value := range values {
+			if err := validateValue(name, schema.Items, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(values) > 1 {
+		return fmt.Errorf("%s must have a single value", name)
+	}
+	return validateValue(name, schema, values[0])
+}
+
+// returns an error if the request parameters or form body do not match the operation
+func (op *OpenAPIOperation) ValidateRequest(r *http.Request) error {
+	query := r.URL.Query()
+	for _, parameter := range op.Parameters {
+		var values []string
+		switch parameter.In {
+		case "query":
+			values = query[parameter.Name]
+		case "header":
+			values = r.Header.Values(parameter.Name)
+		default: // path parameters are always present when the route matches
+			continue
+		}
+		if len(values) == 0 {
+			if parameter.Required {
+				return fmt.Errorf("missing required %s parameter %s", parameter.In, parameter.Name)
+			}
+			continue
+		}
+		if err := validateValues(parameter.Name, parameter.Schema, values); err != nil {
+			return err
+		}
+	}
+
+	if op.RequestBody == nil {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	content, ok := op.RequestBody.Content[mediaType]
+	if !ok {
+		if op.RequestBody.Required || r.ContentLength > 0 {
+			return fmt.Errorf("unsupported content type %s", mediaType)
+		}
+		return nil
+	}
+
+	var err error
+	if mediaType == "multipart/form-data" {
+		err = r.ParseMultipartForm(32 << 20) // the same limit as gin
+	} else {
+		err = r.ParseForm()
+	}
+	if err != nil {
+		return err
+	}
+	schema := content.Schema
+	for _, name := range schema.Required {
+		if len(r.PostForm[name]) == 0 {
+			return fmt.Errorf("missing required field %s", name)
+		}
+	}
+	for name, values := range r.PostForm { // fields which are not described are allowed ie. groupAttributes
+		if property, ok := schema.Properties[name]; ok && len(values) > 0 {
+			if err := validateValues(name, property, values); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// returns an error if the response status is not described by the operation or a json body does not match the schema of the status
+func (op *OpenAPIOperation) ValidateResponse(status int, contentType string, body []byte) error {
+	response, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		response, ok = op.Responses["default"]
+	}
+	if !ok {
+		return fmt.Errorf("response status %d is not described", status)
+	}
+	if response.Ref != "" {
+		response = resolveRef(op.document.Components.Responses, response.Ref, "#/components/responses/")
+		if response == nil {
+			return fmt.Errorf("response status %d refers to an unknown response", status)
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	content, ok := response.Content[mediaType]
+	if mediaType != "application/json" || !ok || content.Schema == nil { // only json bodies are validated
+		return nil
+	}
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Errorf("response body is not json: %w", err)
+	}
+	return op.document.validateJSON("body", content.Schema, value)
+}
+
+// returns an error if the decoded json value does not match the schema
+func (o *OpenAPI) validateJSON(name string, schema *OpenAPISchema, value any) error {
+	if schema == nil {
+		return nil
+	}
+	if schema.Ref != "" {
+		resolved := resolveRef(o.Components.Schemas, schema.Ref, "#/components/schemas/")
+		if resolved == nil {
+			return fmt.Errorf("%s refers to an unknown schema %s", name, schema.Ref)
+		}
+		schema = resolved
+	}
+	if value == nil {
+		if schema.Nullable || (schema.Type == "" && len(schema.AllOf) == 0) {
+			return nil
+		}
+		return fmt.Errorf("%s must not be null", name)
+	}
+	for _, part := range schema.AllOf {
+		if err := o.validateJSON(name, part, value); err != nil {
+			return err
+		}
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s must be an object", name)
+		}
+		for _, property := range schema.Required {
+			if _, ok := object[property]; !ok {
+				return fmt.Errorf("%s is missing required property %s", name, property)
+			}
+		}
+		for property, propertySchema := range schema.Properties {
+			if propertyValue, ok := object[property]; ok {
+				if err := o.validateJSON(name+"."+property, propertySchema, propertyValue); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s must be an array", name)
+		}
+		for i, item := range array {
+			if err := o.validateJSON(fmt.Sprintf("%s[%d]", name, i), schema.Items, item); err != nil {
+				return err
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a string", name)
+		}
+		return validateValue(name, schema, text)
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			return fmt.Errorf("%s must be an integer", name)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s must be a number", name)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s must be a boolean", name)
+		}
+	}
+	return nil
+}
+
+// response writer which keeps a copy of json bodies so that they can be validated after the handler, streams such as /events are not copied
+type openAPIResponseWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *openAPIResponseWriter) copying() bool {
+	return strings.HasPrefix(w.Header().Get("Content-Type"), "application/json")
+}
+
+func (w *openAPIResponseWriter) Write(data []byte) (int, error) {
+	if w.copying() {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *openAPIResponseWriter) WriteString(data string) (int, error) {
+	if w.copying() {
+		w.body.WriteString(data)
+	}
+	return w.ResponseWriter.WriteString(data)
+}
+
+// gin middleware which rejects requests that do not match openapi.json and logs responses that do not match
+func OpenAPIMiddleware(document *OpenAPI, validateRequests bool, validateResponses bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		op := document.Operation(c.Request.Method, c.FullPath())
+		if op == nil { // unmatched routes are handled by gin
+			c.Next()
+			return
+		}
+
+		if validateRequests {
+			if err := op.ValidateRequest(c.Request); err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		var writer *openAPIResponseWriter
+		if validateResponses {
+			writer = &openAPIResponseWriter{ResponseWriter: c.Writer}
+			c.Writer = writer
+		}
+
+		c.Next()
+
+		if validateResponses {
+			if err := op.ValidateResponse(writer.Status(), writer.Header().Get("Content-Type"), writer.body.Bytes()); err != nil {
+				slog.Warn("Response does not match OpenAPI document", RequestIDKey, c.GetString(RequestIDKey), "method", c.Request.Method, "route", c.FullPath(), "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package app
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// config fields which are only read at startup, changes to these are reported until the next restart
+var restartConfigFields = []string{
+	"listenPort",
+	"bindAddress",
+	"unixSocket",
+	"tls",
+	"metrics",
+	"validateRequests",
+	"validateResponses",
+	"tracing",
+	"accountExpiry.policy",
+	"accountExpiry.interval",
+	"softDelete.path",
+	"softDelete.retentionDays",
+	"softDelete.interval",
+	"audit",
+	"webhooks",
+	"membershipExpiry",
+	"sessionStore",
+	"sessionCookieName",
+	"reloadInterval",
+}
+
+// config fields which are copied when a session logs in, changes to these only apply to sessions created after the reload
+var newSessionConfigFields = []string{
+	"ldapURL",
+	"startTLS",
+	"baseDN",
+	"serviceAccount",
+}
+
+// ConfigStatus of the config reloads since startup
+type ConfigStatus struct {
+	Path            string     `json:"path"`
+	Reloads         int        `json:"reloads"`
+	Failures        int        `json:"failures"`
+	LastReload      *time.Time `json:"lastReload"`
+	LastError       string     `json:"lastError"`
+	Applied         []string   `json:"applied"`         // fields changed by the last successful reload which apply to every session
+	NewSessionsOnly []string   `json:"newSessionsOnly"` // fields changed by the last successful reload which only apply to sessions created after it
+	PendingRestart  []string   `json:"pendingRestart"`  // fields which differ from the file but only change on restart
+}
+
+// ConfigReloader holds the active config and replaces it when the config file is reloaded
+type ConfigReloader struct {
+	path    string
+	lock    sync.Mutex
+	config  Config
+	status  ConfigStatus
+	modTime time.Time
+}
+
+func NewConfigReloader(path string, config Config) *ConfigReloader {
+	reloader := &ConfigReloader{
+		path:   path,
+		config: config,
+		status: ConfigStatus{Path: path, Applied: []string{}, NewSessionsOnly: []string{}, PendingRestart: []string{}},
+	}
+	if info, err := os.Stat(path); err == nil {
+		reloader.modTime = info.ModTime()
+	}
+	return reloader
+}
+
+// returns the active config
+func (r *ConfigReloader) Config() Config {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.config
+}
+
+func (r *ConfigReloader) Status() ConfigStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	status := r.status
+	status.Applied = slices.Clone(status.Applied)
+	status.NewSessionsOnly = slices.Clone(status.NewSessionsOnly)
+	status.PendingRestart = slices.Clone(status.PendingRestart)
+	return status
+}
+
+// read and validate the config file and apply the changed fields which can change live, the active config is unchanged if the file is invalid
+func (r *ConfigReloader) Reload() (ConfigStatus, error) {
+	loaded, err := GetConfig(r.path)
+	if err == nil {
+		err = loaded.Validate()
+	}
+
+	r.lock.Lock()
+	now := time.Now().UTC()
+	r.status.LastReload = &now
+	if err != nil {
+		r.status.Failures++
+		r.status.LastError = err.Error()
+		r.lock.Unlock()
+		ConfigReloads.WithLabelValues("failure").Inc()
+		return r.Status(), err
+	}
+
+	active := reflect.ValueOf(&r.config).Elem()
+	next := reflect.ValueOf(&loaded).Elem()
+	applied := []string{}
+	newSessions := []string{}
+	pending := []string{}
+	for _, path := range configDiff(active, next, "") {
+		if matchesConfigField(restartConfigFields, path) { // keep the running value
+			configField(next, path).Set(configField(active, path))
+			pending = append(pending, path)
+		} else if matchesConfigField(newSessionConfigFields, path) {
+			newSessions = append(newSessions, path)
+		} else {
+			applied = append(applied, path)
+		}
+	}
+	if slices.ContainsFunc(applied, func(path string) bool { return strings.HasPrefix(path, "log.") }) {
+		logger, err := NewLogger(os.Stderr, loaded.Log.Level, loaded.Log.Format)
+		if err == nil {
+			slog.SetDefault(logger)
+		}
+	}
+	r.config = loaded
+	r.status.Reloads++
+	r.status.LastError = ""
+	r.status.Applied = applied
+	r.status.NewSessionsOnly = newSessions
+	r.status.PendingRestart = pending
+	r.lock.Unlock()
+
+	ConfigReloads.WithLabelValues("success").Inc()
+	slog.Info("Reloaded config", "path", r.path, "applied", applied, "newSessionsOnly", newSessions, "pendingRestart", pending)
+	return r.Status(), nil
+}
+
+// returns true if the json path is one of the fields or nested under one of them
+func matchesConfigField(fields []string, path string) bool {
+	for _, field := range fields {
+		if path == field || strings.HasPrefix(path, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the json paths of the leaf fields which differ between the configs
+func configDiff(a reflect.Value, b reflect.Value, path string) []string {
+	changed := []string{}
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+		fieldPath := fieldName(field)
+		if path != "" {
+			fieldPath = path + "." + fieldPath
+		}
+		if field.Type.Kind() == reflect.Struct {
+			changed = append(changed, configDiff(a.Field(i), b.Field(i), fieldPath)...)
+		} else if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			changed = append(changed, fieldPath)
+		}
+	}
+	return changed
+}
+
+// returns the field of the config at the json path
+func configField(value reflect.Value, path string) reflect.Value {
+	for name := range strings.SplitSeq(path, ".") {
+		for i := 0; i < value.NumField(); i++ {
+			if fieldName(value.Type().Field(i)) == name {
+				value = value.Field(i)
+				break
+			}
+		}
+	}
+	return value
+}
+
+// reload the config on SIGHUP, and when the modification time of the file changes if the interval is positive
+func (r *ConfigReloader) Watch(interval time.Duration) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-hangup:
+			slog.Info("Received SIGHUP, reloading config", "path", r.path)
+		case <-tick:
+			info, err := os.Stat(r.path)
+			if err != nil || info.ModTime().Equal(r.modTime) {
+				continue
+			}
+			r.modTime = info.ModTime()
+			slog.Info("Config file changed, reloading config", "path", r.path)
+		}
+		if _, err := r.Reload(); err != nil {
+			slog.Error("Error when reloading config, keeping the active config", "path", r.path, "error", err)
+		}
+	}
+}
+
+// returns the active config if the config is being reloaded, otherwise the config given at startup
+func currentConfig(config Config) Config {
+	if ActiveConfig == nil {
+		return config
+	}
+	return ActiveConfig.Config()
+}
+
+// returns the config reload status if the bound user is a member of the admin group
+func (l LDAPClient) GetConfigStatus(reloader *ConfigReloader, adminGroup string) (int, gin.H) {
+	if status, res := l.checkAdmin(adminGroup); status != http.StatusOK {
+		return status, res
+	}
+	return http.StatusOK, gin.H{
+		"ok":     true,
+		"error":  nil,
+		"config": reloader.Status(),
+	}
+}
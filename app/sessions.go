@@ -0,0 +1,220 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// PersistedSession metadata needed to register the session again after a restart, credentials are never stored
+type PersistedSession struct {
+	UUID      string    `json:"uuid"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// default lifetime of persisted sessions if neither sessionStore.maxAge nor sessionCookie.maxAge is set
+const defaultPersistedSessionMaxAge = 24 * time.Hour
+
+// returns the lifetime of persisted sessions from login, sessionStore.maxAge defaults to sessionCookie.maxAge so restored sessions never outlive the ticket cookie
+func (config Config) PersistedSessionMaxAge() time.Duration {
+	if config.SessionStore.MaxAge > 0 {
+		return time.Duration(config.SessionStore.MaxAge) * time.Second
+	}
+	if config.SessionCookie.MaxAge > 0 {
+		return time.Duration(config.SessionCookie.MaxAge) * time.Second
+	}
+	return defaultPersistedSessionMaxAge
+}
+
+// SessionStore persists session metadata to a file so tickets stay valid after a restart
+type SessionStore struct {
+	path     string
+	lock     sync.Mutex
+	sessions map[string]PersistedSession
+}
+
+// returns the secret key read from the path, or generates a new secret key and writes it to the path if it does not exist
+func LoadSecretKey(path string) ([]byte, error) {
+	secretKey, err := os.ReadFile(path)
+	if err == nil {
+		if len(secretKey) < 32 {
+			return nil, errors.New("secret key is shorter than 32 bytes")
+		}
+		return secretKey, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	secretKey = make([]byte, 256)
+	if _, err := rand.Read(secretKey); err != nil {
+		return nil, err
+	}
+	return secretKey, writeFileAtomic(path, secretKey)
+}
+
+// returns a new SessionStore loaded from the path, or an empty store if the file does not exist
+func NewSessionStore(path string) (*SessionStore, error) {
+	store := &SessionStore{
+		path:     path,
+		sessions: make(map[string]PersistedSession),
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var sessions []PersistedSession
+	err = json.Unmarshal(content, &sessions)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		store.sessions[session.UUID] = session
+	}
+
+	return store, nil
+}
+
+func (s *SessionStore) save() error {
+	sessions := []PersistedSession{}
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+
+	content, err := json.Marshal(sessions)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, content)
+}
+
+func (s *SessionStore) Add(uuid string, username string, expiresAt time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sessions[uuid] = PersistedSession{
+		UUID:      uuid,
+		Username:  username,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt.UTC(),
+	}
+	return s.save()
+}
+
+func (s *SessionStore) Delete(uuid string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.sessions[uuid]; !ok {
+		return nil
+	}
+	delete(s.sessions, uuid)
+	return s.save()
+}
+
+func (s *SessionStore) List() []PersistedSession {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sessions := []PersistedSession{}
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// register each persisted session again as the user through the service account with proxied authorization until its expiry, sessions which have no expiry, have expired, or whose user no longer exists or has expired are deleted
+func RestoreSessions(config Config, store *SessionStore) int {
+	restored := 0
+	maxAge := config.PersistedSessionMaxAge()
+	for _, session := range store.List() {
+		expiresAt := session.ExpiresAt
+		if limit := session.CreatedAt.Add(maxAge); limit.Before(expiresAt) { // a lower max age also applies to sessions persisted before the change
+			expiresAt = limit
+		}
+		if session.ExpiresAt.IsZero() || !time.Now().Before(expiresAt) { // sessions without an expiry would otherwise never end
+			if err := store.Delete(session.UUID); err != nil {
+				slog.Error("Error when deleting session", "error", err)
+			}
+			continue
+		}
+		client, err := NewProxiedLDAPClient(config, session.Username)
+		if err != nil { // the server is unreachable, keep the session for the next restart
+			slog.Warn("Error when restoring session", "username", session.Username, "error", err)
+			continue
+		}
+		err = client.checkProxiedUser(session.Username)
+		if err != nil {
+			client.Close()
+			slog.Warn("Error when restoring session", "username", session.Username, "error", err)
+			if err := store.Delete(session.UUID); err != nil {
+				slog.Error("Error when deleting session", "error", err)
+			}
+			continue
+		}
+		client.ctx = nil
+		client.expiresAt = expiresAt
+		SetSession(session.UUID, client)
+		restored++
+	}
+	return restored
+}
+
+// unbind and close the connection of the session
+func (l *LDAPClient) Close() {
+	if l == nil || l.client == nil {
+		return
+	}
+	if err := l.client.Unbind(); err != nil {
+		l.client.Close()
+	}
+}
+
+// close the connection of every session, persisted sessions are kept so they can be restored
+func CloseSessions() int {
+	ldapSessionsLock.Lock()
+	closing := LDAPSessions
+	LDAPSessions = make(map[string]*LDAPClient) // handlers still running after a drain timeout find no session
+	ldapSessionsLock.Unlock()
+	for _, session := range closing {
+		session.Close()
+	}
+	return len(closing)
+}
+
+// returns a new http server whose request contexts are cancelled when it shuts down so long running streams such as /events end
+func NewServer(addr string, handler http.Handler) *http.Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+	server.RegisterOnShutdown(cancel)
+	return server
+}
+
+// stop accepting connections, wait up to the timeout for in-flight requests, and then close every session
+func ShutdownServer(server *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil { // drain timeout exceeded, close the remaining connections
+		server.Close()
+	}
+	closed := CloseSessions()
+	slog.Info("Closed sessions", "count", closed)
+	return err
+}
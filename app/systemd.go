@@ -0,0 +1,200 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// first file descriptor passed by systemd socket activation, see sd_listen_fds(3)
+const listenFDsStart = 3
+
+// user agent of watchdog health check requests, which are logged at debug level
+const healthCheckUserAgent = "proxmoxaas-ldap-watchdog"
+
+// returns the listeners passed by systemd socket activation, or nil if the process was not socket activated
+func SystemdListeners() ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID") // the sockets are not inherited by child processes
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+
+	listeners := []net.Listener{}
+	for fd := listenFDsStart; fd < listenFDsStart+count; fd++ {
+		syscall.CloseOnExec(fd)
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		listener, err := net.FileListener(file)
+		file.Close() // FileListener duplicates the descriptor
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, fmt.Errorf("file descriptor %d is not a listening socket: %w", fd, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// returns a listener on the unix socket at the path with the permissions and group, a stale socket left at the path is removed
+func ListenUnix(path string, mode os.FileMode, group string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		gid, _ := strconv.Atoi(g.Gid)
+		if err := os.Chown(path, -1, gid); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// returns the permissions of the unix socket, defaults to 0660 so only the owner and group can connect
+func unixSocketMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0660, nil
+	}
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || parsed > 0777 {
+		return 0, errors.New("must be octal permissions ie. 0660")
+	}
+	return os.FileMode(parsed), nil
+}
+
+// send the state to the systemd notification socket, returns false if the process is not supervised by systemd, see sd_notify(3)
+func SdNotify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	if socket[0] == '@' { // abstract socket
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// returns the watchdog timeout set by WatchdogSec in the service unit, or 0 if the watchdog is disabled for this process
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// notify the systemd watchdog at half the watchdog timeout until the context is cancelled, the watchdog is only notified while the health check passes so that systemd restarts a process which stopped serving
+func RunWatchdog(ctx context.Context, timeout time.Duration, check func(context.Context) error) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkCtx, cancel := context.WithTimeout(ctx, timeout/4)
+			err := check(checkCtx)
+			cancel()
+			if err != nil {
+				slog.Error("Health check failed, not notifying systemd watchdog", "error", err)
+				continue
+			}
+			if _, err := SdNotify("WATCHDOG=1"); err != nil {
+				slog.Error("Error when notifying systemd watchdog", "error", err)
+			}
+		}
+	}
+}
+
+// response writer which only records the status of a health check request
+type healthCheckWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *healthCheckWriter) Header() http.Header { return w.header }
+
+func (w *healthCheckWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return len(data), nil
+}
+
+func (w *healthCheckWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// returns a health check which serves GET /version through the handler and reads the session count, failing if either blocks past the deadline or the request does not succeed
+func HandlerHealthCheck(handler http.Handler) func(context.Context) error {
+	return func(ctx context.Context) error {
+		done := make(chan int, 1)
+		go func() {
+			SessionCount() // blocks if the sessions lock is held indefinitely
+			request, _ := http.NewRequestWithContext(ctx, http.MethodGet, "/version", nil)
+			request.RemoteAddr = "127.0.0.1:0"
+			request.Header.Set("User-Agent", healthCheckUserAgent)
+			writer := &healthCheckWriter{header: http.Header{}}
+			handler.ServeHTTP(writer, request)
+			done <- writer.status
+		}()
+		select {
+		case <-ctx.Done():
+			return errors.New("health check did not complete before the deadline")
+		case status := <-done:
+			if status != http.StatusOK {
+				return fmt.Errorf("health check responded with status %d", status)
+			}
+			return nil
+		}
+	}
+}
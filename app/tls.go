@@ -0,0 +1,190 @@
+package app
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// CertificateReloader serves a certificate and key pair which is loaded again when either file changes
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+// returns a new CertificateReloader with the certificate and key loaded from the files
+func NewCertificateReloader(certFile string, keyFile string) (*CertificateReloader, error) {
+	reloader := &CertificateReloader{certFile: certFile, keyFile: keyFile}
+	_, err := reloader.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// returns the latest modification time of the certificate and key files
+func (r *CertificateReloader) filesModTime() (time.Time, error) {
+	latest := time.Time{}
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// load the certificate and key if either file changed since the last load, returns true if the certificate was replaced
+func (r *CertificateReloader) Reload() (bool, error) {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return false, err
+	}
+	r.lock.RLock()
+	unchanged := r.cert != nil && modTime.Equal(r.modTime)
+	r.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil { // the files may be part way through being replaced, keep serving the current certificate
+		return false, err
+	}
+	r.lock.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.lock.Unlock()
+	return true, nil
+}
+
+// returns the current certificate, used as the GetCertificate of the tls config
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+// reload the certificate on SIGHUP and at the interval
+func (r *CertificateReloader) Watch(interval time.Duration) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hangup:
+		case <-ticker.C:
+		}
+		reloaded, err := r.Reload()
+		if err != nil {
+			slog.Error("Error when reloading TLS certificate, keeping the current certificate", "certFile", r.certFile, "error", err)
+		} else if reloaded {
+			slog.Info("Reloaded TLS certificate", "certFile", r.certFile)
+		}
+	}
+}
+
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// returns the id of each named cipher suite, only suites without known security issues are allowed
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	ids := []uint16{}
+	for _, name := range names {
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// returns the tls config of the API server serving the certificate of the reloader
+func NewTLSConfig(config Config, certificates *CertificateReloader) (*tls.Config, error) {
+	minVersion, ok := tlsVersions[config.TLS.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS version %s", config.TLS.MinVersion)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: certificates.GetCertificate,
+	}
+	if len(config.TLS.CipherSuites) > 0 { // only applies to TLS 1.2, TLS 1.3 suites are not configurable
+		ids, err := cipherSuiteIDs(config.TLS.CipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.CipherSuites = ids
+	}
+	if config.TLS.ClientAuth.CAFile != "" { // client certificates are verified against the CA and mapped by ClientCertMiddleware
+		pool, err := loadCertPool(config.TLS.ClientAuth.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if config.TLS.ClientAuth.Required {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return tlsConfig, nil
+}
+
+// returns the protocols served by the API server, HTTP/2 is only served over TLS
+func NewProtocols(config Config) *http.Protocols {
+	protocols := &http.Protocols{}
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(!config.TLS.DisableHTTP2)
+	return protocols
+}
+
+// returns the port of the first TCP listener, or the fallback if there is none ie. only unix sockets were passed by systemd
+func TCPListenerPort(listeners []net.Listener, fallback int) int {
+	for _, listener := range listeners {
+		if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+			return addr.Port
+		}
+	}
+	return fallback
+}
+
+// returns a handler which redirects every request to the same host and path over HTTPS on the port
+func RedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil { // the host has no port
+			host = r.Host
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead { // keep the method and body
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
@@ -0,0 +1,207 @@
+package app
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation scope of every span
+const tracerName = "proxmoxaas-ldap"
+
+// W3C trace context propagator used to continue the trace of the traceparent header
+var tracePropagator = propagation.TraceContext{}
+
+// returns the tracer of the global tracer provider, which does not record spans unless tracing is enabled
+func tracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(tracerName, trace.WithInstrumentationVersion(AppVersion))
+}
+
+// returns the span exporter of the tracing config, or nil if tracing is disabled
+func NewSpanExporter(ctx context.Context, config Config, stdout io.Writer) (sdktrace.SpanExporter, error) {
+	switch config.Tracing.Exporter {
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithWriter(stdout))
+	case "otlp":
+		return otlptracehttp.New(ctx,
+			otlptracehttp.WithEndpointURL(config.Tracing.Endpoint),
+			otlptracehttp.WithHeaders(config.Tracing.Headers),
+			otlptracehttp.WithTimeout(10*time.Second), // failed exports are retried with backoff until the timeout
+		)
+	default:
+		return nil, nil
+	}
+}
+
+// returns a tracer provider which exports batches of sampled spans, new traces are sampled at tracing.sampleRatio and continued traces follow the sampled flag of their parent
+func NewTracerProvider(config Config, exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	serviceName := config.Tracing.ServiceName
+	if serviceName == "" {
+		serviceName = "proxmoxaas-ldap"
+	}
+	interval := time.Duration(config.Tracing.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ratio := 1.0
+	if config.Tracing.SampleRatio != nil {
+		ratio = *config.Tracing.SampleRatio
+	}
+	queueSize := config.Tracing.MaxQueueSize
+	if queueSize <= 0 {
+		queueSize = sdktrace.DefaultMaxQueueSize
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithBatcher(exporter, // spans are dropped rather than buffered once the queue is full
+			sdktrace.WithBatchTimeout(interval),
+			sdktrace.WithMaxQueueSize(queueSize),
+			sdktrace.WithMaxExportBatchSize(min(queueSize, sdktrace.DefaultMaxExportBatchSize)),
+		),
+	)
+}
+
+// installs the tracer provider of the tracing config as the global provider, returns nil if tracing is disabled
+func StartTracing(ctx context.Context, config Config) (*sdktrace.TracerProvider, error) {
+	exporter, err := NewSpanExporter(ctx, config, os.Stdout)
+	if err != nil || exporter == nil {
+		return nil, err
+	}
+	provider := NewTracerProvider(config, exporter)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(tracePropagator)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Error("Error when exporting spans", "error", err)
+	}))
+	return provider, nil
+}
+
+// gin middleware which traces each request as a server span continuing any valid traceparent header
+func TracingMiddleware(provider trace.TracerProvider) gin.HandlerFunc {
+	tracer := provider.Tracer(tracerName, trace.WithInstrumentationVersion(AppVersion))
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx := tracePropagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", c.Request.URL.Path),
+				attribute.String(RequestIDKey, c.GetString(RequestIDKey)),
+			),
+		)
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		span.SetAttributes(attribute.Int("http.response.status_code", c.Writer.Status()))
+		if c.Writer.Status() >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(c.Writer.Status()))
+		}
+	}
+}
+
+// start a client span for an LDAP operation as a child of the request span of the client
+func (l LDAPClient) startSpan(name string, dn string) trace.Span {
+	ctx := l.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, span := tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("ldap.dn", dn)))
+	return span
+}
+
+// end an LDAP span with the result code of the error
+func finishLDAPSpan(span trace.Span, err error) {
+	code := uint16(ldap.LDAPResultSuccess)
+	if err != nil {
+		code = ldap.LDAPResultOther
+		if ldapErr, ok := err.(*ldap.Error); ok {
+			code = ldapErr.ResultCode
+		}
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.SetAttributes(attribute.Int("ldap.result_code", int(code)))
+	span.End()
+}
+
+// returns the controls with the proxied authorization control added if the client performs operations as another identity
+func (l LDAPClient) withAuthz(controls []ldap.Control) []ldap.Control {
+	if l.authzid == "" {
+		return controls
+	}
+	return append(controls, NewControlProxiedAuthorization(l.authzid))
+}
+
+func (l LDAPClient) search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	searchRequest.Controls = l.withAuthz(searchRequest.Controls)
+	span := l.startSpan("ldap.search", searchRequest.BaseDN)
+	span.SetAttributes(attribute.String("ldap.filter", searchRequest.Filter))
+	result, err := l.client.Search(searchRequest)
+	if result != nil {
+		span.SetAttributes(attribute.Int("ldap.entries", len(result.Entries)))
+	}
+	finishLDAPSpan(span, err)
+	return result, err
+}
+
+func (l LDAPClient) add(addRequest *ldap.AddRequest) error {
+	addRequest.Controls = l.withAuthz(addRequest.Controls)
+	span := l.startSpan("ldap.add", addRequest.DN)
+	err := l.client.Add(addRequest)
+	finishLDAPSpan(span, err)
+	return err
+}
+
+func (l LDAPClient) modify(modifyRequest *ldap.ModifyRequest) error {
+	modifyRequest.Controls = l.withAuthz(modifyRequest.Controls)
+	span := l.startSpan("ldap.modify", modifyRequest.DN)
+	err := l.client.Modify(modifyRequest)
+	finishLDAPSpan(span, err)
+	return err
+}
+
+func (l LDAPClient) del(delRequest *ldap.DelRequest) error {
+	delRequest.Controls = l.withAuthz(delRequest.Controls)
+	span := l.startSpan("ldap.delete", delRequest.DN)
+	err := l.client.Del(delRequest)
+	finishLDAPSpan(span, err)
+	return err
+}
+
+func (l LDAPClient) modifyDN(modifyDNRequest *ldap.ModifyDNRequest) error {
+	modifyDNRequest.Controls = l.withAuthz(modifyDNRequest.Controls)
+	span := l.startSpan("ldap.modifydn", modifyDNRequest.DN)
+	span.SetAttributes(attribute.String("ldap.new_rdn", modifyDNRequest.NewRDN))
+	err := l.client.ModifyDN(modifyDNRequest)
+	finishLDAPSpan(span, err)
+	return err
+}
+
+func (l LDAPClient) bind(dn string, password string) error {
+	span := l.startSpan("ldap.bind", dn)
+	err := l.client.Bind(dn, password)
+	finishLDAPSpan(span, err)
+	return err
+}
@@ -0,0 +1,579 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// DeletedEntry of a user or group which has been moved to the trash
+type DeletedEntry struct {
+	Type        string             `json:"type"` // user or group
+	ID          string             `json:"id"`   // uid of users or cn of groups
+	OriginalDN  string             `json:"originalDN"`
+	TrashDN     string             `json:"trashDN"`
+	DeletedAt   time.Time          `json:"deletedAt"`
+	Memberships []string           `json:"memberships"`        // DNs of groups for users or DNs of members for groups
+	Parents     []string           `json:"parents,omitempty"`  // DNs of the groups a group was a member of
+	Expiries    []MembershipExpiry `json:"expiries,omitempty"` // expiries of the memberships, restored with them
+}
+
+// DeletedEntryStore persists deleted entries to a json file
+type DeletedEntryStore struct {
+	path      string
+	retention time.Duration
+	lock      sync.Mutex
+	entries   map[string]DeletedEntry // keyed by trash DN since an id can be deleted again after it is recreated
+}
+
+// returns a new DeletedEntryStore loaded from the path, or an empty store if the file does not exist
+func NewDeletedEntryStore(path string, retention time.Duration) (*DeletedEntryStore, error) {
+	store := &DeletedEntryStore{
+		path:      path,
+		retention: retention,
+		entries:   make(map[string]DeletedEntry),
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries []DeletedEntry
+	err = json.Unmarshal(content, &entries)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		store.entries[strings.ToLower(entry.TrashDN)] = entry
+	}
+
+	return store, nil
+}
+
+func (s *DeletedEntryStore) save() error {
+	entries := []DeletedEntry{}
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+
+	content, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, content)
+}
+
+func (s *DeletedEntryStore) Add(entry DeletedEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entries[strings.ToLower(entry.TrashDN)] = entry
+	return s.save()
+}
+
+// returns the most recently deleted entry of the type and id
+func (s *DeletedEntryStore) Get(entryType string, id string) (DeletedEntry, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var latest DeletedEntry
+	found := false
+	for _, entry := range s.entries {
+		if entry.Type == entryType && strings.EqualFold(entry.ID, id) && (!found || entry.DeletedAt.After(latest.DeletedAt)) {
+			latest = entry
+			found = true
+		}
+	}
+	return latest, found
+}
+
+func (s *DeletedEntryStore) Delete(trashDN string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.entries, strings.ToLower(trashDN))
+	return s.save()
+}
+
+// returns all deleted entries
+func (s *DeletedEntryStore) List() []DeletedEntry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	result := []DeletedEntry{}
+	for _, entry := range s.entries {
+		result = append(result, entry)
+	}
+	return result
+}
+
+// returns all deleted entries whose retention window has passed at the given time
+func (s *DeletedEntryStore) Expired(now time.Time) []DeletedEntry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	result := []DeletedEntry{}
+	for _, entry := range s.entries {
+		if !entry.DeletedAt.Add(s.retention).After(now) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// returns the time after which the entry can no longer be restored
+func (s *DeletedEntryStore) RestoreUntil(entry DeletedEntry) time.Time {
+	return entry.DeletedAt.Add(s.retention)
+}
+
+func DeletedEntryToGin(entry DeletedEntry, restoreUntil time.Time) gin.H {
+	return gin.H{
+		"type":         entry.Type,
+		"id":           entry.ID,
+		"dn":           entry.OriginalDN,
+		"trashDN":      entry.TrashDN,
+		"deletedAt":    entry.DeletedAt,
+		"restoreUntil": restoreUntil,
+		"memberships":  entry.Memberships,
+		"parents":      entry.Parents,
+	}
+}
+
+// returns the deleted entries which the bound user is able to read in the trash
+func (l LDAPClient) GetDeletedEntries(store *DeletedEntryStore) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "GetDeletedEntries", time.Now(), &res)
+	visible, err := l.searchDNs(l.trashDN(), "(|(objectClass=inetOrgPerson)(objectClass=groupOfNames)(objectClass=groupOfURLs))")
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) { // trash has not been created yet
+		visible, err = []string{}, nil
+	}
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+
+	visibleSet := map[string]bool{}
+	for _, dn := range visible {
+		visibleSet[strings.ToLower(dn)] = true
+	}
+
+	var results = []gin.H{} // create list of results
+	for _, entry := range store.List() {
+		if visibleSet[strings.ToLower(entry.TrashDN)] {
+			results = append(results, DeletedEntryToGin(entry, store.RestoreUntil(entry)))
+		}
+	}
+
+	return http.StatusOK, gin.H{
+		"ok":      true,
+		"error":   nil,
+		"entries": results,
+	}
+}
+
+// returns the RDN of an entry in the trash, which is unique so that an id can be deleted again after it is recreated
+func trashRDN(attribute string, id string) string {
+	suffix, _ := uuid.NewV4()
+	return fmt.Sprintf("%s=%s-%s", attribute, id, suffix.String())
+}
+
+// delete a user the same way for requests and background jobs, moving it to the trash if soft delete is enabled and removing its membership expiries
+func (l LDAPClient) deleteUser(uid string) (status int, res gin.H) {
+	if DeletedEntries != nil { // soft delete moves the user to the trash so that it can be restored
+		status, res = l.SoftDelUser(uid, DeletedEntries)
+	} else {
+		status, res = l.DelUser(uid)
+	}
+	if status == http.StatusOK {
+		clearEntryExpiries(fmt.Sprintf("uid=%s,%s", uid, l.peopledn))
+	}
+	return status, res
+}
+
+// move a user to the trash after removing it from all groups, recording the groups and their expiries so they can be restored
+func (l LDAPClient) SoftDelUser(uid string, store *DeletedEntryStore) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "SoftDelUser", time.Now(), &res)
+	userDN := fmt.Sprintf("uid=%s,%s", uid, l.peopledn)
+
+	groups, err := l.searchDNs(l.groupsdn, fmt.Sprintf("(&(objectClass=groupOfNames)(member=%s))", ldap.EscapeFilter(userDN)))
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+
+	err = l.ensureTrash()
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+
+	// remove the user from all groups first, otherwise refint would rename the member values to the trash DN
+	removed := []string{}
+	for _, groupDN := range groups {
+		status, res := l.delGroupMember(userDN, groupDN)
+		if status != http.StatusOK {
+			l.addGroupMemberships(userDN, removed) // revert the removed memberships
+			return status, res
+		}
+		removed = append(removed, groupDN)
+	}
+
+	rdn := trashRDN("uid", uid)
+	trashDN := l.trashDN()
+	modifyDNRequest := ldap.NewModifyDNRequest(userDN, rdn, true, trashDN)
+	err = l.modifyDN(modifyDNRequest) // move user to trash
+	if err != nil {
+		l.addGroupMemberships(userDN, removed) // revert the removed memberships
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+
+	entry := DeletedEntry{
+		Type:        "user",
+		ID:          uid,
+		OriginalDN:  userDN,
+		TrashDN:     rdn + "," + trashDN,
+		DeletedAt:   time.Now().UTC(),
+		Memberships: groups,
+		Expiries:    entryExpiries(userDN),
+	}
+	err = store.Add(entry)
+	if err != nil { // without a record the entry could never be restored or purged, so undo the delete
+		if err := l.modifyDN(ldap.NewModifyDNRequest(entry.TrashDN, fmt.Sprintf("uid=%s", uid), true, l.peopledn)); err != nil {
+			slog.Error("Error when moving user out of the trash", "dn", entry.TrashDN, "error", err)
+		}
+		l.addGroupMemberships(userDN, removed)
+		return http.StatusInternalServerError, gin.H{
+			"ok":    false,
+			"error": ldap.NewError(ldap.LDAPResultOther, err),
+		}
+	}
+
+	return http.StatusOK, gin.H{
+		"ok":    true,
+		"error": nil,
+	}
+}
+
+// add the member to each group, returns the groups which could not be added to with their errors
+func (l LDAPClient) addGroupMemberships(memberDN string, groups []string) (restored []string, failed []gin.H) {
+	restored = []string{}
+	failed = []gin.H{}
+	for _, groupDN := range groups {
+		status, res := l.addGroupMember(memberDN, groupDN)
+		if status != http.StatusOK {
+			failed = append(failed, gin.H{"group": groupDN, "error": LDAPErrorToGin(res["error"].(error))})
+			continue
+		}
+		restored = append(restored, groupDN)
+	}
+	return restored, failed
+}
+
+// move a user from the trash back to people and restore its group memberships
+func (l LDAPClient) RestoreUser(uid string, store *DeletedEntryStore) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "RestoreUser", time.Now(), &res)
+	entry, ok := store.Get("user", uid)
+	if !ok {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": ldap.NewError(ldap.LDAPResultNoSuchObject, fmt.Errorf("user %s is not in the trash", uid)),
+		}
+	}
+	if !store.RestoreUntil(entry).After(time.Now()) {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": ldap.NewError(ldap.LDAPResultUnwillingToPerform, fmt.Errorf("user %s is past the restore window", uid)),
+		}
+	}
+
+	modifyDNRequest := ldap.NewModifyDNRequest(entry.TrashDN, fmt.Sprintf("uid=%s", uid), true, l.peopledn)
+	err := l.modifyDN(modifyDNRequest) // move user back to people
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+
+	restored, failed := l.addGroupMemberships(entry.OriginalDN, entry.Memberships)
+	restoreExpiries(entry.Expiries, func(expiry MembershipExpiry) bool {
+		return slices.ContainsFunc(restored, func(groupDN string) bool { return strings.EqualFold(groupDN, expiry.GroupDN) })
+	})
+
+	err = store.Delete(entry.TrashDN)
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{
+			"ok":    false,
+			"error": ldap.NewError(ldap.LDAPResultOther, err),
+		}
+	}
+
+	status = http.StatusOK
+	if len(failed) > 0 {
+		status = http.StatusMultiStatus
+	}
+
+	return status, gin.H{
+		"ok":       len(failed) == 0,
+		"error":    nil,
+		"restored": restored,
+		"failed":   failed,
+	}
+}
+
+// move a group to the trash after removing all of its members and removing it from its parent groups, recording both and their expiries so they can be restored
+func (l LDAPClient) SoftDelGroup(gid string, store *DeletedEntryStore) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "SoftDelGroup", time.Now(), &res)
+	groupDN := fmt.Sprintf("cn=%s,%s", gid, l.groupsdn)
+
+	values, err := l.getGroupMembers(groupDN)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform) { // dynamic groups do not have members to record
+		values, err = []string{}, nil
+	}
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+	members := l.hidePlaceholderMembers(groupDN, values)
+
+	parents, err := l.searchDNs(l.groupsdn, fmt.Sprintf("(&(objectClass=groupOfNames)(member=%s))", ldap.EscapeFilter(groupDN)))
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+
+	err = l.ensureTrash()
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+
+	// remove all members first so that the memberOf overlay removes the group from its members
+	if len(members) > 0 {
+		modifyRequest := ldap.NewModifyRequest(groupDN, nil)
+		if len(l.getPlaceholderMembers(groupDN, values)) == 0 { // groupOfNames requires at least one member
+			modifyRequest.Add("member", []string{l.placeholderMember(groupDN)})
+		}
+		modifyRequest.Delete("member", members)
+		err = l.modify(modifyRequest)
+		if err != nil {
+			return http.StatusBadRequest, gin.H{
+				"ok":    false,
+				"error": err,
+			}
+		}
+	}
+	revertMembers := func() {
+		if len(members) > 0 {
+			l.setRawGroupMembers(groupDN, members)
+		}
+	}
+
+	// remove the group from its parents, otherwise refint would rename the member values to the trash DN
+	detached := []string{}
+	for _, parentDN := range parents {
+		status, res := l.delGroupMember(groupDN, parentDN)
+		if status != http.StatusOK {
+			l.addGroupMemberships(groupDN, detached) // revert the removed parents and members
+			revertMembers()
+			return status, res
+		}
+		detached = append(detached, parentDN)
+	}
+
+	rdn := trashRDN("cn", gid)
+	trashDN := l.trashDN()
+	modifyDNRequest := ldap.NewModifyDNRequest(groupDN, rdn, true, trashDN)
+	err = l.modifyDN(modifyDNRequest) // move group to trash
+	if err != nil {
+		l.addGroupMemberships(groupDN, detached) // revert the removed parents and members
+		revertMembers()
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+
+	entry := DeletedEntry{
+		Type:        "group",
+		ID:          gid,
+		OriginalDN:  groupDN,
+		TrashDN:     rdn + "," + trashDN,
+		DeletedAt:   time.Now().UTC(),
+		Memberships: members,
+		Parents:     parents,
+		Expiries:    entryExpiries(groupDN),
+	}
+	err = store.Add(entry)
+	if err != nil { // without a record the entry could never be restored or purged, so undo the delete
+		if err := l.modifyDN(ldap.NewModifyDNRequest(entry.TrashDN, fmt.Sprintf("cn=%s", gid), true, l.groupsdn)); err != nil {
+			slog.Error("Error when moving group out of the trash", "dn", entry.TrashDN, "error", err)
+		}
+		l.addGroupMemberships(groupDN, detached)
+		revertMembers()
+		return http.StatusInternalServerError, gin.H{
+			"ok":    false,
+			"error": ldap.NewError(ldap.LDAPResultOther, err),
+		}
+	}
+
+	return http.StatusOK, gin.H{
+		"ok":    true,
+		"error": nil,
+	}
+}
+
+// move a group from the trash back to groups and restore its members and parent groups
+func (l LDAPClient) RestoreGroup(gid string, store *DeletedEntryStore) (status int, res gin.H) {
+	defer observeLDAPOperation(l.requestid, "RestoreGroup", time.Now(), &res)
+	entry, ok := store.Get("group", gid)
+	if !ok {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": ldap.NewError(ldap.LDAPResultNoSuchObject, fmt.Errorf("group %s is not in the trash", gid)),
+		}
+	}
+	if !store.RestoreUntil(entry).After(time.Now()) {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": ldap.NewError(ldap.LDAPResultUnwillingToPerform, fmt.Errorf("group %s is past the restore window", gid)),
+		}
+	}
+
+	modifyDNRequest := ldap.NewModifyDNRequest(entry.TrashDN, fmt.Sprintf("cn=%s", gid), true, l.groupsdn)
+	err := l.modifyDN(modifyDNRequest) // move group back to groups
+	if err != nil {
+		return http.StatusBadRequest, gin.H{
+			"ok":    false,
+			"error": err,
+		}
+	}
+
+	if len(entry.Memberships) > 0 {
+		err = l.setRawGroupMembers(entry.OriginalDN, entry.Memberships)
+		if err != nil {
+			return http.StatusBadRequest, gin.H{
+				"ok":    false,
+				"error": err,
+			}
+		}
+	}
+	restored, failed := l.addGroupMemberships(entry.OriginalDN, entry.Parents)
+	restoreExpiries(entry.Expiries, func(expiry MembershipExpiry) bool {
+		if strings.EqualFold(expiry.GroupDN, entry.OriginalDN) { // members are always restored
+			return true
+		}
+		return slices.ContainsFunc(restored, func(groupDN string) bool { return strings.EqualFold(groupDN, expiry.GroupDN) })
+	})
+
+	err = store.Delete(entry.TrashDN)
+	if err != nil {
+		return http.StatusInternalServerError, gin.H{
+			"ok":    false,
+			"error": ldap.NewError(ldap.LDAPResultOther, err),
+		}
+	}
+
+	status = http.StatusOK
+	if len(failed) > 0 {
+		status = http.StatusMultiStatus
+	}
+
+	return status, gin.H{
+		"ok":       len(failed) == 0,
+		"error":    nil,
+		"restored": restored,
+		"failed":   failed,
+	}
+}
+
+// replace the members of a group including any placeholders with the given members
+func (l LDAPClient) setRawGroupMembers(groupDN string, members []string) error {
+	modifyRequest := ldap.NewModifyRequest(groupDN, nil)
+	modifyRequest.Replace("member", members)
+	return l.modify(modifyRequest)
+}
+
+// create the trash organizational unit if it does not exist
+func (l LDAPClient) ensureTrash() error {
+	trashDN := l.trashDN()
+	_, err := l.getObjectClasses(trashDN)
+	if err == nil {
+		return nil
+	} else if !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return err
+	}
+
+	addRequest := ldap.NewAddRequest(trashDN, nil)
+	addRequest.Attribute("objectClass", []string{"organizationalUnit"})
+	addRequest.Attribute("ou", []string{getRDNValue(trashDN)})
+	return l.add(addRequest)
+}
+
+// permanently delete all entries in the trash whose retention window has passed using the service account
+func PurgeDeletedEntries(config Config, store *DeletedEntryStore, now time.Time) {
+	expired := store.Expired(now)
+	if len(expired) == 0 {
+		return
+	}
+
+	client, err := NewServiceLDAPClient(config)
+	if err != nil {
+		slog.Error("Error when purging deleted entries", "error", err)
+		return
+	}
+	defer client.client.Close()
+
+	for _, entry := range expired {
+		err := client.del(ldap.NewDelRequest(entry.TrashDN, nil))
+		status, res := http.StatusOK, gin.H{"ok": true, "error": nil}
+		if err != nil {
+			status, res = http.StatusBadRequest, gin.H{"ok": false, "error": err}
+		}
+		AuditJob(client, entry.Type+".purge", entry.OriginalDN, "", nil, status, res)
+		if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			slog.Error("Error when purging deleted entry", "dn", entry.TrashDN, "error", err)
+			continue
+		}
+		slog.Info("Purged deleted entry", "dn", entry.TrashDN)
+		err = store.Delete(entry.TrashDN)
+		if err != nil {
+			slog.Error("Error when saving deleted entries", "error", err)
+		}
+	}
+}
+
+// run PurgeDeletedEntries at the configured interval
+func RunPurgeDeletedEntries(config Config, store *DeletedEntryStore) {
+	interval := time.Duration(config.SoftDelete.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		PurgeDeletedEntries(currentConfig(config), store, time.Now())
+	}
+}
@@ -1,38 +1,119 @@
 package app
 
 import (
-	"encoding/json"
+	"fmt"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-ldap/ldap/v3"
 )
 
 type Config struct {
-	ListenPort        int    `json:"listenPort"`
-	LdapURL           string `json:"ldapURL"`
-	StartTLS          bool   `json:"startTLS"`
-	BaseDN            string `json:"baseDN"`
+	ListenPort  int    `json:"listenPort"`
+	BindAddress string `json:"bindAddress"`
+	UnixSocket  struct {
+		Path  string `json:"path"`
+		Mode  string `json:"mode"`
+		Group string `json:"group"`
+	} `json:"unixSocket"`
+	LdapURL                string   `json:"ldapURL"`
+	StartTLS               bool     `json:"startTLS"`
+	BaseDN                 string   `json:"baseDN"`
+	GroupAttributes        []string `json:"groupAttributes"`
+	GroupPlaceholderMember string   `json:"groupPlaceholderMember"`
+	UseMatchingRuleInChain bool     `json:"useMatchingRuleInChain"`
+	AdminGroup             string   `json:"adminGroup"`
+	Metrics                bool     `json:"metrics"`
+	ValidateRequests       bool     `json:"validateRequests"`
+	ValidateResponses      bool     `json:"validateResponses"`
+	TLS                    struct {
+		CertFile       string   `json:"certFile"`
+		KeyFile        string   `json:"keyFile"`
+		MinVersion     string   `json:"minVersion"`
+		CipherSuites   []string `json:"cipherSuites"`
+		ReloadInterval int      `json:"reloadInterval"`
+		RedirectPort   int      `json:"redirectPort"`
+		DisableHTTP2   bool     `json:"disableHTTP2"`
+		ClientAuth     struct {
+			CAFile   string              `json:"caFile"`
+			Required bool                `json:"required"`
+			Mappings []ClientCertMapping `json:"mappings"`
+		} `json:"clientAuth"`
+	} `json:"tls"`
+	Log struct {
+		Level  string `json:"level"`
+		Format string `json:"format"`
+	} `json:"log"`
+	Tracing struct {
+		Exporter     string            `json:"exporter"`
+		Endpoint     string            `json:"endpoint"`
+		ServiceName  string            `json:"serviceName"`
+		Headers      map[string]string `json:"headers"`
+		Interval     int               `json:"interval"`
+		SampleRatio  *float64          `json:"sampleRatio"`
+		MaxQueueSize int               `json:"maxQueueSize"`
+	} `json:"tracing"`
+	ServiceAccount struct {
+		BindDN   string `json:"bindDN"`
+		Password string `json:"password"`
+	} `json:"serviceAccount"`
+	AccountExpiry struct {
+		Attribute string `json:"attribute"`
+		Policy    string `json:"policy"`
+		Interval  int    `json:"interval"`
+	} `json:"accountExpiry"`
+	SoftDelete struct {
+		Path          string `json:"path"`
+		TrashDN       string `json:"trashDN"`
+		RetentionDays int    `json:"retentionDays"`
+		Interval      int    `json:"interval"`
+	} `json:"softDelete"`
+	Audit struct {
+		Path       string `json:"path"`
+		Syslog     bool   `json:"syslog"`
+		AdminGroup string `json:"adminGroup"` // deprecated, moved to adminGroup
+	} `json:"audit"`
+	Webhooks struct {
+		Path          string              `json:"path"`
+		Subscribers   []WebhookSubscriber `json:"subscribers"`
+		MaxAttempts   int                 `json:"maxAttempts"`
+		Backoff       int                 `json:"backoff"`
+		Timeout       int                 `json:"timeout"`
+		RetentionDays int                 `json:"retentionDays"`
+	} `json:"webhooks"`
+	MembershipExpiry struct {
+		Path     string `json:"path"`
+		Interval int    `json:"interval"`
+	} `json:"membershipExpiry"`
+	ShutdownTimeout int `json:"shutdownTimeout"`
+	ReloadInterval  int `json:"reloadInterval"`
+	SessionStore    struct {
+		Path          string `json:"path"`
+		SecretKeyPath string `json:"secretKeyPath"`
+		MaxAge        int    `json:"maxAge"`
+	} `json:"sessionStore"`
 	SessionCookieName string `json:"sessionCookieName"`
 	SessionCookie     struct {
 		Path     string `json:"path"`
 		HttpOnly bool   `json:"httpOnly"`
 		Secure   bool   `json:"secure"`
 		MaxAge   int    `json:"maxAge"`
+		SameSite string `json:"sameSite"`
 	}
-}
-
-func GetConfig(configPath string) (Config, error) {
-	content, err := os.ReadFile(configPath)
-	if err != nil {
-		return Config{}, err
-	}
-	var config Config
-	err = json.Unmarshal(content, &config)
-	if err != nil {
-		return Config{}, err
-	}
-	return config, nil
+	CORS struct {
+		AllowedOrigins   []string `json:"allowedOrigins"`
+		AllowedMethods   []string `json:"allowedMethods"`
+		AllowedHeaders   []string `json:"allowedHeaders"`
+		AllowCredentials bool     `json:"allowCredentials"`
+		MaxAge           int      `json:"maxAge"`
+	} `json:"cors"`
+	CSRF struct {
+		Enabled bool `json:"enabled"`
+	} `json:"csrf"`
 }
 
 type Login struct { // login body struct
@@ -41,11 +122,12 @@ type Login struct { // login body struct
 }
 
 type LDAPUserAttributes struct {
-	CN       string
-	SN       string
-	Mail     string
-	UID      string
-	MemberOf []string
+	CN        string
+	SN        string
+	Mail      string
+	UID       string
+	MemberOf  []string
+	ExpiresAt *time.Time
 }
 
 type LDAPUser struct {
@@ -70,18 +152,35 @@ func LDAPUserToGin(user LDAPUser) gin.H {
 	return gin.H{
 		"dn": user.DN,
 		"attributes": gin.H{
-			"cn":       user.Attributes.CN,
-			"sn":       user.Attributes.SN,
-			"mail":     user.Attributes.Mail,
-			"uid":      user.Attributes.UID,
-			"memberOf": user.Attributes.MemberOf,
+			"cn":        user.Attributes.CN,
+			"sn":        user.Attributes.SN,
+			"mail":      user.Attributes.Mail,
+			"uid":       user.Attributes.UID,
+			"memberOf":  user.Attributes.MemberOf,
+			"expiresAt": user.Attributes.ExpiresAt,
 		},
 	}
 }
 
 type LDAPGroupAttributes struct {
-	CN     string
-	Member []string
+	CN               string
+	Member           []string
+	MemberURL        []string
+	Description      string
+	Owner            []string
+	BusinessCategory []string
+	Extra            map[string][]string
+}
+
+// attributes which are mapped to named fields of LDAPGroupAttributes, any others are considered extra
+var groupNamedAttributes = map[string]bool{
+	"cn":               true,
+	"member":           true,
+	"memberURL":        true,
+	"description":      true,
+	"owner":            true,
+	"businessCategory": true,
+	"objectClass":      true,
 }
 
 type LDAPGroup struct {
@@ -90,53 +189,221 @@ type LDAPGroup struct {
 }
 
 func LDAPEntryToLDAPGroup(entry *ldap.Entry) LDAPGroup {
+	var extra map[string][]string
+	for _, attr := range entry.Attributes { // collect any attributes not mapped to a named field
+		if groupNamedAttributes[attr.Name] {
+			continue
+		}
+		if extra == nil {
+			extra = make(map[string][]string)
+		}
+		extra[attr.Name] = attr.Values
+	}
+
 	return LDAPGroup{
 		DN: entry.DN,
 		Attributes: LDAPGroupAttributes{
-			CN:     entry.GetAttributeValue("cn"),
-			Member: entry.GetAttributeValues("member"),
+			CN:               entry.GetAttributeValue("cn"),
+			Member:           entry.GetAttributeValues("member"),
+			MemberURL:        entry.GetAttributeValues("memberURL"),
+			Description:      entry.GetAttributeValue("description"),
+			Owner:            entry.GetAttributeValues("owner"),
+			BusinessCategory: entry.GetAttributeValues("businessCategory"),
+			Extra:            extra,
 		},
 	}
 }
 
 func LDAPGroupToGin(group LDAPGroup) gin.H {
+	attributes := gin.H{
+		"cn":               group.Attributes.CN,
+		"member":           group.Attributes.Member,
+		"memberURL":        group.Attributes.MemberURL,
+		"description":      group.Attributes.Description,
+		"owner":            group.Attributes.Owner,
+		"businessCategory": group.Attributes.BusinessCategory,
+	}
+	for name, values := range group.Attributes.Extra {
+		attributes[name] = values
+	}
 	return gin.H{
-		"dn": group.DN,
-		"attributes": gin.H{
-			"cn":     group.Attributes.CN,
-			"member": group.Attributes.Member,
-		},
+		"dn":         group.DN,
+		"attributes": attributes,
 	}
 }
 
 type UserOptional struct { // add or modify user body struct
-	CN           string `form:"cn"`
-	SN           string `form:"sn"`
-	Mail         string `form:"mail"`
-	UserPassword string `form:"userpassword"`
+	CN           string    `form:"cn"`
+	SN           string    `form:"sn"`
+	Mail         string    `form:"mail"`
+	UserPassword string    `form:"userpassword"`
+	ExpiresAt    time.Time `form:"expiresAt"`
 }
 
 type UserRequired struct { // add or modify user body struct
-	CN           string `form:"cn" binding:"required"`
-	SN           string `form:"sn" binding:"required"`
-	Mail         string `form:"mail" binding:"required"`
-	UserPassword string `form:"userpassword" binding:"required"`
+	CN           string    `form:"cn" binding:"required"`
+	SN           string    `form:"sn" binding:"required"`
+	Mail         string    `form:"mail" binding:"required"`
+	UserPassword string    `form:"userpassword" binding:"required"`
+	ExpiresAt    time.Time `form:"expiresAt"`
+}
+
+// returns the value of an account expiry attribute, shadowExpire is stored as days since epoch and any other attribute as generalized time
+// shadowExpire can only store whole days, so times after midnight UTC are rounded up to the next midnight rather than expiring the account early
+func FormatExpiry(attribute string, expiresAt time.Time) string {
+	if strings.EqualFold(attribute, "shadowExpire") {
+		days := expiresAt.Unix() / 86400
+		if expiresAt.Unix()%86400 != 0 || expiresAt.Nanosecond() != 0 {
+			days++
+		}
+		return strconv.FormatInt(days, 10)
+	}
+	return expiresAt.UTC().Format("20060102150405Z")
+}
+
+// returns the time of an account expiry attribute value, or nil if the value means the account never expires
+func ParseExpiry(attribute string, value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if strings.EqualFold(attribute, "shadowExpire") {
+		days, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if days < 0 { // -1 is commonly used to mean never expires
+			return nil, nil
+		}
+		expiresAt := time.Unix(days*86400, 0).UTC()
+		return &expiresAt, nil
+	}
+	for _, layout := range []string{"20060102150405Z", "20060102150405.999999999Z", "20060102150405Z0700", "20060102150405.999999999Z0700"} {
+		expiresAt, err := time.Parse(layout, value)
+		if err == nil {
+			expiresAt = expiresAt.UTC()
+			return &expiresAt, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid generalized time %s", value)
 }
 
 type Group struct { // add or modify group body struct
+	MemberURL        []string            `form:"memberURL"` // creates a dynamic groupOfURLs group instead of a groupOfNames group
+	Description      string              `form:"description"`
+	Owner            []string            `form:"owner"`
+	BusinessCategory []string            `form:"businessCategory"`
+	Attributes       map[string][]string `form:"-"` // extra attributes allowed by config.GroupAttributes
+	Clear            []string            `form:"-"` // optional attributes sent with only empty values, which are removed from an existing group
+}
+
+// optional group attributes which can be cleared in addition to config.GroupAttributes
+var clearableGroupAttributes = []string{"description", "owner", "businessCategory"}
+
+// returns the values which are not empty
+func nonEmptyValues(values []string) []string {
+	result := []string{}
+	for _, value := range values {
+		if value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// MemberURL parsed from an LDAP URL of the form ldap:///<base>??<scope>?<filter> as used by groupOfURLs
+type MemberURL struct {
+	BaseDN string
+	Scope  int
+	Filter string
+}
+
+func ParseMemberURL(memberURL string) (MemberURL, error) {
+	parsed, err := url.Parse(memberURL)
+	if err != nil {
+		return MemberURL{}, err
+	}
+	if parsed.Scheme != "ldap" && parsed.Scheme != "ldaps" {
+		return MemberURL{}, fmt.Errorf("memberURL %s must use the ldap scheme", memberURL)
+	}
+
+	result := MemberURL{
+		BaseDN: strings.TrimPrefix(parsed.Path, "/"),
+		Scope:  ldap.ScopeBaseObject, // default scope is base as defined by RFC 4516
+		Filter: "(objectClass=*)",
+	}
+
+	parts := strings.Split(parsed.RawQuery, "?") // attributes ? scope ? filter ? extensions
+	if len(parts) > 1 && parts[1] != "" {
+		switch strings.ToLower(parts[1]) {
+		case "base":
+			result.Scope = ldap.ScopeBaseObject
+		case "one":
+			result.Scope = ldap.ScopeSingleLevel
+		case "sub":
+			result.Scope = ldap.ScopeWholeSubtree
+		default:
+			return MemberURL{}, fmt.Errorf("memberURL %s has invalid scope %s", memberURL, parts[1])
+		}
+	}
+	if len(parts) > 2 && parts[2] != "" {
+		filter, err := url.PathUnescape(parts[2])
+		if err != nil {
+			return MemberURL{}, err
+		}
+		if _, err := ldap.CompileFilter(filter); err != nil {
+			return MemberURL{}, fmt.Errorf("memberURL %s has invalid filter: %s", memberURL, err.Error())
+		}
+		result.Filter = filter
+	}
+
+	return result, nil
+}
+
+type GroupMember struct { // add group member body struct
+	ExpiresAt time.Time `form:"expiresAt"`
+}
+
+type GroupMembers struct { // replace group members body struct
+	Members []string `form:"members"`
+}
+
+type UserGroups struct { // replace user groups body struct
+	Groups []string `form:"groups"`
+}
+
+// write the content to a temporary file and then rename it so that the file is never partially written
+func writeFileAtomic(path string, content []byte) error {
+	err := os.WriteFile(path+".tmp", content, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// returns the value of the first RDN of a DN, or the DN itself if it cannot be parsed
+func getRDNValue(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return dn
+	}
+	return parsed.RDNs[0].Attributes[0].Value
 }
 
 func HandleResponse(response gin.H) gin.H {
 	if response["error"] != nil {
 		err := response["error"].(error)
-		LDAPerr := err.(*ldap.Error)
-		response["error"] = gin.H{
-			"code":    LDAPerr.ResultCode,
-			"result":  ldap.LDAPResultCodeMap[LDAPerr.ResultCode],
-			"message": LDAPerr.Err.Error(),
-		}
+		response["error"] = LDAPErrorToGin(err)
 		return response
 	} else {
 		return response
 	}
 }
+
+func LDAPErrorToGin(err error) gin.H {
+	LDAPerr := err.(*ldap.Error)
+	return gin.H{
+		"code":    LDAPerr.ResultCode,
+		"result":  ldap.LDAPResultCodeMap[LDAPerr.ResultCode],
+		"message": LDAPerr.Err.Error(),
+	}
+}
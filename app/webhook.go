@@ -0,0 +1,387 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// WebhookSubscriber receives the events it is subscribed to, or all events if none are listed
+type WebhookSubscriber struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// returns true if the subscriber should receive the event type
+func (s WebhookSubscriber) Subscribed(eventType string) bool {
+	return len(s.Events) == 0 || slices.Contains(s.Events, "*") || slices.Contains(s.Events, eventType)
+}
+
+// WebhookEvent sent as the json body of a webhook delivery
+type WebhookEvent struct {
+	ID     string    `json:"id"`
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Target string    `json:"target"`
+	Member string    `json:"member,omitempty"` // member DN for group membership events
+}
+
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// WebhookDelivery of a single event to a single subscriber
+type WebhookDelivery struct {
+	ID          string       `json:"id"`
+	URL         string       `json:"url"`
+	Secret      string       `json:"secret,omitempty"` // secret of the subscriber when the event was queued, never returned by the api
+	Event       WebhookEvent `json:"event"`
+	Status      string       `json:"status"`
+	Attempts    int          `json:"attempts"`
+	NextAttempt time.Time    `json:"nextAttempt"`
+	LastStatus  int          `json:"lastStatus"` // http status of the last attempt, 0 if the request failed
+	LastError   string       `json:"lastError"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	FinishedAt  *time.Time   `json:"finishedAt"`
+}
+
+// WebhookQueue persists webhook deliveries to a json file until they are delivered or have failed
+type WebhookQueue struct {
+	path        string
+	lock        sync.Mutex
+	subscribers []WebhookSubscriber
+	maxAttempts int
+	backoff     time.Duration
+	retention   time.Duration
+	deliveries  map[string]WebhookDelivery
+	wake        chan struct{}
+}
+
+// returns a new WebhookQueue loaded from the path, or an empty queue if the file does not exist
+func NewWebhookQueue(path string, subscribers []WebhookSubscriber, maxAttempts int, backoff time.Duration, retention time.Duration) (*WebhookQueue, error) {
+	if maxAttempts <= 0 {
+		maxAttempts = 10
+	}
+	if backoff <= 0 {
+		backoff = 5 * time.Second
+	}
+	if retention <= 0 {
+		retention = 7 * 24 * time.Hour
+	}
+	queue := &WebhookQueue{
+		path:        path,
+		subscribers: subscribers,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		retention:   retention,
+		deliveries:  make(map[string]WebhookDelivery),
+		wake:        make(chan struct{}, 1),
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return queue, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var deliveries []WebhookDelivery
+	err = json.Unmarshal(content, &deliveries)
+	if err != nil {
+		return nil, err
+	}
+	for _, delivery := range deliveries {
+		queue.deliveries[delivery.ID] = delivery
+	}
+
+	return queue, nil
+}
+
+func (q *WebhookQueue) save() error {
+	content, err := json.Marshal(q.list())
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(q.path, content)
+}
+
+func (q *WebhookQueue) list() []WebhookDelivery {
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range q.deliveries {
+		deliveries = append(deliveries, delivery)
+	}
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+	})
+	return deliveries
+}
+
+// queue a delivery of the event to each subscriber of the event type
+func (q *WebhookQueue) Enqueue(event WebhookEvent) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, subscriber := range q.subscribers {
+		if !subscriber.Subscribed(event.Type) {
+			continue
+		}
+		id, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+		q.deliveries[id.String()] = WebhookDelivery{
+			ID:          id.String(),
+			URL:         subscriber.URL,
+			Secret:      subscriber.Secret,
+			Event:       event,
+			Status:      WebhookPending,
+			NextAttempt: event.Time,
+			CreatedAt:   event.Time,
+		}
+	}
+	err := q.save()
+
+	select { // wake the delivery worker without blocking if it is already awake
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// returns the delivery without its secret
+func (q *WebhookQueue) Get(id string) (WebhookDelivery, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delivery, ok := q.deliveries[id]
+	delivery.Secret = ""
+	return delivery, ok
+}
+
+// returns all deliveries with the status without their secrets, or all deliveries if the status is empty
+func (q *WebhookQueue) List(status string) []WebhookDelivery {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range q.list() {
+		if status == "" || delivery.Status == status {
+			delivery.Secret = ""
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries
+}
+
+// returns the pending deliveries whose next attempt is due at the given time
+func (q *WebhookQueue) Due(now time.Time) []WebhookDelivery {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range q.list() {
+		if delivery.Status == WebhookPending && !delivery.NextAttempt.After(now) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries
+}
+
+// record the result of a delivery attempt, failed attempts are retried with exponential backoff until maxAttempts is reached
+func (q *WebhookQueue) Attempted(id string, now time.Time, status int, err error) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delivery, ok := q.deliveries[id]
+	if !ok {
+		return nil
+	}
+
+	delivery.Attempts++
+	delivery.LastStatus = status
+	delivery.LastError = ""
+	if err == nil {
+		delivery.Status = WebhookDelivered
+		delivery.FinishedAt = &now
+	} else {
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= q.maxAttempts {
+			delivery.Status = WebhookFailed
+			delivery.FinishedAt = &now
+		} else {
+			delivery.NextAttempt = now.Add(q.Backoff(delivery.Attempts))
+		}
+	}
+	q.deliveries[id] = delivery
+	return q.save()
+}
+
+// returns the delay before the next attempt after the given number of failed attempts, capped at one hour
+func (q *WebhookQueue) Backoff(attempts int) time.Duration {
+	delay := q.backoff
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	return min(delay, time.Hour)
+}
+
+// remove delivered and failed deliveries which finished before the retention period
+func (q *WebhookQueue) Prune(now time.Time) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	changed := false
+	for id, delivery := range q.deliveries {
+		if delivery.FinishedAt != nil && delivery.FinishedAt.Add(q.retention).Before(now) {
+			delete(q.deliveries, id)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return q.save()
+}
+
+// returns the hex encoded HMAC-SHA256 of the body using the secret
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// attempt a single delivery, returning the http status and an error if the delivery was not accepted
+func deliverWebhook(client *http.Client, delivery WebhookDelivery, secret string) (int, error) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return 0, err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-PAASLDAP-Event", delivery.Event.Type)
+	request.Header.Set("X-PAASLDAP-Delivery", delivery.ID)
+	request.Header.Set("X-PAASLDAP-Signature", "sha256="+SignWebhook(secret, body))
+
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("subscriber responded with status %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+// attempt all deliveries which are due at the given time
+func DeliverWebhooks(queue *WebhookQueue, client *http.Client, now time.Time) {
+	for _, delivery := range queue.Due(now) {
+		status, err := deliverWebhook(client, delivery, delivery.Secret)
+		if err != nil {
+			slog.Warn("Error when delivering webhook", "delivery", delivery.ID, "url", delivery.URL, "error", err)
+		}
+		err = queue.Attempted(delivery.ID, time.Now(), status, err)
+		if err != nil {
+			slog.Error("Error when saving webhook queue", "error", err)
+		}
+	}
+}
+
+// run DeliverWebhooks whenever an event is queued and every second for retries, pruning finished deliveries hourly
+func RunWebhookDeliveries(config Config, queue *WebhookQueue) {
+	timeout := time.Duration(config.Webhooks.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+
+	ticker := time.NewTicker(time.Second)
+	pruned := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-queue.wake:
+		}
+		DeliverWebhooks(queue, client, time.Now())
+		if time.Since(pruned) > time.Hour {
+			if err := queue.Prune(time.Now()); err != nil {
+				slog.Error("Error when saving webhook queue", "error", err)
+			}
+			pruned = time.Now()
+		}
+	}
+}
+
+// queue a webhook event after a successful change if webhooks are enabled
+func PublishEvent(session *LDAPClient, eventType string, target string, member string, status int) {
+	if Webhooks == nil || status < 200 || status > 299 {
+		return
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		slog.Error("Error when publishing webhook event", "error", err)
+		return
+	}
+	err = Webhooks.Enqueue(WebhookEvent{
+		ID:     id.String(),
+		Type:   eventType,
+		Time:   time.Now().UTC(),
+		Actor:  session.binddn,
+		Target: target,
+		Member: member,
+	})
+	if err != nil {
+		slog.Error("Error when saving webhook queue", "error", err)
+	}
+}
+
+// returns the webhook deliveries with the status if the bound user is a member of the admin group
+func (l LDAPClient) GetWebhookDeliveries(queue *WebhookQueue, adminGroup string, status string) (int, gin.H) {
+	if status, res := l.checkAdmin(adminGroup); status != http.StatusOK {
+		return status, res
+	}
+	return http.StatusOK, gin.H{
+		"ok":         true,
+		"error":      nil,
+		"deliveries": queue.List(status),
+	}
+}
+
+// returns a single webhook delivery if the bound user is a member of the admin group
+func (l LDAPClient) GetWebhookDelivery(queue *WebhookQueue, adminGroup string, id string) (int, gin.H) {
+	if status, res := l.checkAdmin(adminGroup); status != http.StatusOK {
+		return status, res
+	}
+	delivery, ok := queue.Get(id)
+	if !ok {
+		return http.StatusNotFound, gin.H{
+			"ok":    false,
+			"error": ldap.NewError(ldap.LDAPResultNoSuchObject, fmt.Errorf("webhook delivery %s does not exist", id)),
+		}
+	}
+	return http.StatusOK, gin.H{
+		"ok":       true,
+		"error":    nil,
+		"delivery": delivery,
+	}
+}
@@ -0,0 +1,333 @@
+// Package client is a typed client for the ProxmoxAAS LDAP API which manages the session ticket cookie.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client of a ProxmoxAAS LDAP instance
+type Client struct {
+	baseURL   *url.URL
+	http      *http.Client
+	csrfToken string
+}
+
+// header carrying the CSRF token issued by Login
+const csrfHeader = "X-CSRF-Token"
+
+// returns a new Client for the base url ie. http://localhost:8082, the session cookie is kept in a cookie jar
+func New(baseURL string) (*Client, error) {
+	return NewWithHTTPClient(baseURL, &http.Client{Timeout: 30 * time.Second})
+}
+
+// returns a new Client using the http client, a cookie jar is added if the http client has none
+func NewWithHTTPClient(baseURL string, httpClient *http.Client) (*Client, error) {
+	parsed, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if httpClient.Jar == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+		httpClient.Jar = jar
+	}
+	return &Client{baseURL: parsed, http: httpClient}, nil
+}
+
+// returns the session cookies so they can be stored and restored with SetCookies
+func (c *Client) Cookies() []*http.Cookie {
+	return c.http.Jar.Cookies(c.baseURL)
+}
+
+// restore session cookies returned by Cookies
+func (c *Client) SetCookies(cookies []*http.Cookie) {
+	c.http.Jar.SetCookies(c.baseURL, cookies)
+}
+
+// returns the CSRF token of the session so it can be stored and restored with SetCSRFToken
+func (c *Client) CSRFToken() string {
+	return c.csrfToken
+}
+
+// restore the CSRF token returned by CSRFToken
+func (c *Client) SetCSRFToken(token string) {
+	c.csrfToken = token
+}
+
+// send a request with an optional form body and decode the json response into result if it is not nil
+func (c *Client) do(ctx context.Context, method string, path string, form url.Values, result any) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL.String()+path, body)
+	if err != nil {
+		return err
+	}
+	if form != nil {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if c.csrfToken != "" && method != http.MethodGet {
+		request.Header.Set(csrfHeader, c.csrfToken)
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return decodeError(response.StatusCode, content)
+	}
+	if token := response.Header.Get(csrfHeader); token != "" { // issued by Login
+		c.csrfToken = token
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(content, result)
+}
+
+// returns the error of an unsuccessful response, which is either an LDAP error from HandleResponse or a plain message
+func decodeError(status int, content []byte) error {
+	apiErr := &Error{StatusCode: status, Message: http.StatusText(status)}
+	var body struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(content, &body); err != nil || len(body.Error) == 0 {
+		return apiErr
+	}
+
+	var ldapErr struct {
+		Code    uint16 `json:"code"`
+		Result  string `json:"result"`
+		Message string `json:"message"`
+	}
+	var message string
+	if err := json.Unmarshal(body.Error, &ldapErr); err == nil && ldapErr.Result != "" {
+		apiErr.Code = ldapErr.Code
+		apiErr.Result = ldapErr.Result
+		apiErr.Message = ldapErr.Message
+	} else if err := json.Unmarshal(body.Error, &message); err == nil && message != "" {
+		apiErr.Message = message
+	}
+	return apiErr
+}
+
+func escape(segment string) string {
+	return url.PathEscape(segment)
+}
+
+// create a session ticket, the cookie is kept by the client for later requests
+func (c *Client) Login(ctx context.Context, username string, password string) error {
+	return c.do(ctx, http.MethodPost, "/ticket", url.Values{"username": {username}, "password": {password}}, nil)
+}
+
+// delete the session ticket
+func (c *Client) Logout(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "/ticket", nil, nil)
+}
+
+func (c *Client) ListUsers(ctx context.Context) ([]User, error) {
+	var result struct {
+		Users []User `json:"users"`
+	}
+	err := c.do(ctx, http.MethodGet, "/users", nil, &result)
+	return result.Users, err
+}
+
+func (c *Client) GetUser(ctx context.Context, uid string) (User, error) {
+	var result struct {
+		User User `json:"user"`
+	}
+	err := c.do(ctx, http.MethodGet, "/users/"+escape(uid), nil, &result)
+	return result.User, err
+}
+
+func userForm(user UserFields) url.Values {
+	form := url.Values{}
+	if user.CN != "" {
+		form.Set("cn", user.CN)
+	}
+	if user.SN != "" {
+		form.Set("sn", user.SN)
+	}
+	if user.Mail != "" {
+		form.Set("mail", user.Mail)
+	}
+	if user.UserPassword != "" {
+		form.Set("userpassword", user.UserPassword)
+	}
+	if !user.ExpiresAt.IsZero() {
+		form.Set("expiresAt", user.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	return form
+}
+
+// create a user, cn, sn, mail and userpassword are required, the user is modified instead if it already exists
+func (c *Client) CreateUser(ctx context.Context, uid string, user UserFields) error {
+	return c.do(ctx, http.MethodPost, "/users/"+escape(uid), userForm(user), nil)
+}
+
+// update the non empty fields of an existing user
+func (c *Client) UpdateUser(ctx context.Context, uid string, user UserFields) error {
+	return c.do(ctx, http.MethodPost, "/users/"+escape(uid), userForm(user), nil)
+}
+
+// delete a user, or move it to the trash if soft delete is enabled
+func (c *Client) DeleteUser(ctx context.Context, uid string) error {
+	return c.do(ctx, http.MethodDelete, "/users/"+escape(uid), nil, nil)
+}
+
+// restore a soft deleted user
+func (c *Client) RestoreUser(ctx context.Context, uid string) error {
+	return c.do(ctx, http.MethodPost, "/users/"+escape(uid)+"/restore", nil, nil)
+}
+
+// returns the cn of each group the user is a direct member of
+func (c *Client) GetUserGroups(ctx context.Context, uid string) ([]string, error) {
+	var result struct {
+		Groups []string `json:"groups"`
+	}
+	err := c.do(ctx, http.MethodGet, "/users/"+escape(uid)+"/groups", nil, &result)
+	return result.Groups, err
+}
+
+// replace the groups of a user, an error is returned with the changes if some groups could not be changed
+func (c *Client) SetUserGroups(ctx context.Context, uid string, gids []string) (MembershipChanges, error) {
+	var result MembershipChanges
+	err := c.do(ctx, http.MethodPut, "/users/"+escape(uid)+"/groups", url.Values{"groups": gids}, &result)
+	if err == nil && len(result.Failed) > 0 {
+		err = &Error{StatusCode: http.StatusMultiStatus, Message: "some groups could not be changed"}
+	}
+	return result, err
+}
+
+// returns the cn of each group the user is a direct or nested member of
+func (c *Client) GetUserEffectiveGroups(ctx context.Context, uid string) ([]string, error) {
+	var result struct {
+		Groups []string `json:"groups"`
+	}
+	err := c.do(ctx, http.MethodGet, "/users/"+escape(uid)+"/effective-groups", nil, &result)
+	return result.Groups, err
+}
+
+func (c *Client) ListGroups(ctx context.Context) ([]Group, error) {
+	var result struct {
+		Groups []Group `json:"groups"`
+	}
+	err := c.do(ctx, http.MethodGet, "/groups", nil, &result)
+	return result.Groups, err
+}
+
+func (c *Client) GetGroup(ctx context.Context, gid string) (Group, error) {
+	var result struct {
+		Group Group `json:"group"`
+	}
+	err := c.do(ctx, http.MethodGet, "/groups/"+escape(gid), nil, &result)
+	return result.Group, err
+}
+
+func groupForm(group GroupFields) url.Values {
+	form := url.Values{}
+	for name, values := range group.Attributes {
+		form[name] = values
+	}
+	if len(group.MemberURL) > 0 {
+		form["memberURL"] = group.MemberURL
+	}
+	if group.Description != "" {
+		form.Set("description", group.Description)
+	}
+	if len(group.Owner) > 0 {
+		form["owner"] = group.Owner
+	}
+	if len(group.BusinessCategory) > 0 {
+		form["businessCategory"] = group.BusinessCategory
+	}
+	for _, name := range group.Clear { // a field sent with only an empty value clears the attribute
+		form[name] = []string{""}
+	}
+	return form
+}
+
+// create a group, the group is modified instead if it already exists
+func (c *Client) CreateGroup(ctx context.Context, gid string, group GroupFields) error {
+	return c.do(ctx, http.MethodPost, "/groups/"+escape(gid), groupForm(group), nil)
+}
+
+// update the non empty fields of an existing group and remove the attributes in Clear
+func (c *Client) UpdateGroup(ctx context.Context, gid string, group GroupFields) error {
+	return c.do(ctx, http.MethodPost, "/groups/"+escape(gid), groupForm(group), nil)
+}
+
+// delete a group, or move it to the trash if soft delete is enabled
+func (c *Client) DeleteGroup(ctx context.Context, gid string) error {
+	return c.do(ctx, http.MethodDelete, "/groups/"+escape(gid), nil, nil)
+}
+
+// restore a soft deleted group
+func (c *Client) RestoreGroup(ctx context.Context, gid string) error {
+	return c.do(ctx, http.MethodPost, "/groups/"+escape(gid)+"/restore", nil, nil)
+}
+
+// add a user to a group, the membership expires at expiresAt if it is not zero
+func (c *Client) AddGroupMember(ctx context.Context, gid string, uid string, expiresAt time.Time) error {
+	form := url.Values{}
+	if !expiresAt.IsZero() {
+		form.Set("expiresAt", expiresAt.UTC().Format(time.RFC3339))
+	}
+	return c.do(ctx, http.MethodPost, "/groups/"+escape(gid)+"/members/"+escape(uid), form, nil)
+}
+
+func (c *Client) RemoveGroupMember(ctx context.Context, gid string, uid string) error {
+	return c.do(ctx, http.MethodDelete, "/groups/"+escape(gid)+"/members/"+escape(uid), nil, nil)
+}
+
+// replace the user members of a group, uids which do not exist are returned in NotFound
+func (c *Client) SetGroupMembers(ctx context.Context, gid string, uids []string) (MembershipChanges, error) {
+	var result MembershipChanges
+	err := c.do(ctx, http.MethodPut, "/groups/"+escape(gid)+"/members", url.Values{"members": uids}, &result)
+	return result, err
+}
+
+// returns the uid of each user who is a direct or nested member of the group
+func (c *Client) GetGroupEffectiveMembers(ctx context.Context, gid string) ([]string, error) {
+	var result struct {
+		Members []string `json:"members"`
+	}
+	err := c.do(ctx, http.MethodGet, "/groups/"+escape(gid)+"/effective-members", nil, &result)
+	return result.Members, err
+}
+
+// add the child group as a member of the group
+func (c *Client) AddSubgroup(ctx context.Context, gid string, childgid string) error {
+	return c.do(ctx, http.MethodPost, "/groups/"+escape(gid)+"/subgroups/"+escape(childgid), nil, nil)
+}
+
+func (c *Client) RemoveSubgroup(ctx context.Context, gid string, childgid string) error {
+	return c.do(ctx, http.MethodDelete, "/groups/"+escape(gid)+"/subgroups/"+escape(childgid), nil, nil)
+}
+
+// returns the API version
+func (c *Client) Version(ctx context.Context) (string, error) {
+	var result struct {
+		Version string `json:"version"`
+	}
+	err := c.do(ctx, http.MethodGet, "/version", nil, &result)
+	return result.Version, err
+}
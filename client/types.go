@@ -0,0 +1,123 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type UserAttributes struct {
+	CN             string               `json:"cn"`
+	SN             string               `json:"sn"`
+	Mail           string               `json:"mail"`
+	UID            string               `json:"uid"`
+	MemberOf       []string             `json:"memberOf"`
+	ExpiresAt      *time.Time           `json:"expiresAt"`
+	MemberOfExpiry map[string]time.Time `json:"memberOfExpiry"` // only returned if membership expiry is enabled
+}
+
+// User as returned by LDAPUserToGin
+type User struct {
+	DN         string         `json:"dn"`
+	Attributes UserAttributes `json:"attributes"`
+}
+
+type GroupAttributes struct {
+	CN               string               `json:"cn"`
+	Member           []string             `json:"member"`
+	MemberURL        []string             `json:"memberURL"`
+	Description      string               `json:"description"`
+	Owner            []string             `json:"owner"`
+	BusinessCategory []string             `json:"businessCategory"`
+	MemberExpiry     map[string]time.Time `json:"memberExpiry"` // only returned if membership expiry is enabled
+	Extra            map[string][]string  `json:"-"`            // extra attributes allowed by groupAttributes
+}
+
+// decode the named attributes and collect any others into Extra
+func (g *GroupAttributes) UnmarshalJSON(data []byte) error {
+	type named GroupAttributes // avoid recursing into this method
+	var attributes named
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for _, name := range []string{"cn", "member", "memberURL", "description", "owner", "businessCategory", "memberExpiry"} {
+		delete(all, name)
+	}
+	for name, raw := range all {
+		var values []string
+		if err := json.Unmarshal(raw, &values); err != nil {
+			continue // not a multi valued attribute
+		}
+		if attributes.Extra == nil {
+			attributes.Extra = make(map[string][]string)
+		}
+		attributes.Extra[name] = values
+	}
+	*g = GroupAttributes(attributes)
+	return nil
+}
+
+// Group as returned by LDAPGroupToGin
+type Group struct {
+	DN         string          `json:"dn"`
+	Attributes GroupAttributes `json:"attributes"`
+}
+
+// UserFields to create or update a user, empty fields are not changed when updating
+type UserFields struct {
+	CN           string
+	SN           string
+	Mail         string
+	UserPassword string
+	ExpiresAt    time.Time
+}
+
+// GroupFields to create or update a group, empty fields are not changed when updating
+type GroupFields struct {
+	MemberURL        []string // creates a dynamic groupOfURLs group instead of a groupOfNames group
+	Description      string
+	Owner            []string
+	BusinessCategory []string
+	Attributes       map[string][]string // extra attributes allowed by groupAttributes
+	Clear            []string            // description, owner, businessCategory, or extra attributes to remove when updating a group
+}
+
+// MembershipChanges returned when replacing the members of a group or the groups of a user
+type MembershipChanges struct {
+	Added    []string         `json:"added"`
+	Removed  []string         `json:"removed"`
+	NotFound []string         `json:"notfound"`
+	Failed   []map[string]any `json:"failed"`
+}
+
+// Error returned by the API, Code is the LDAP result code or 0 if the error did not come from LDAP
+type Error struct {
+	StatusCode int
+	Code       uint16
+	Result     string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Result != "" {
+		return fmt.Sprintf("LDAP Result Code %d %q: %s", e.Code, e.Result, e.Message)
+	}
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Message)
+}
+
+// returns true if the error is an API error with the LDAP result code
+func IsErrorWithCode(err error, code uint16) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Result != "" && apiErr.Code == code
+}
+
+// returns true if the error is caused by a missing or expired session
+func IsUnauthorized(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == 401
+}
@@ -0,0 +1,586 @@
+// Package ctl implements the proxmoxaas-ldap ctl admin commands using the API client.
+package ctl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"proxmoxaas-ldap/client"
+
+	"golang.org/x/term"
+)
+
+const usage = `usage: proxmoxaas-ldap ctl [options] <command> [arguments]
+
+commands:
+  login [--username uid] [--password-stdin]
+  logout
+  users list
+  users get <uid|->
+  users create <uid|-> --cn cn --sn sn --mail mail --password password [--expires-at time]
+  users update <uid|-> [--cn cn] [--sn sn] [--mail mail] [--password password] [--expires-at time]
+  users delete <uid|->
+  groups list
+  groups get <gid|->
+  groups create <gid|-> [--description text] [--owner dn] [--member-url url]
+  groups update <gid|-> [--description text] [--owner dn] [--member-url url]
+  groups delete <gid|->
+  members list <gid>
+  members add <gid> <uid|-> [--expires-at time]
+  members remove <gid> <uid|->
+
+an id of - reads ids one per line from stdin, or for create and update a json array or stream of objects
+
+options:
+`
+
+// Credentials stored in the credentials file after login
+type Credentials struct {
+	URL       string         `json:"url"`
+	Cookies   []*http.Cookie `json:"cookies"`
+	CSRFToken string         `json:"csrfToken,omitempty"`
+}
+
+// returns the default credentials file path in the user config directory
+func DefaultCredentialsPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".proxmoxaas-ldap-credentials.json"
+	}
+	return filepath.Join(dir, "proxmoxaas-ldap", "credentials.json")
+}
+
+func ReadCredentials(path string) (Credentials, error) {
+	var credentials Credentials
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return credentials, nil
+	} else if err != nil {
+		return credentials, err
+	}
+	err = json.Unmarshal(content, &credentials)
+	return credentials, err
+}
+
+// write the credentials readable only by the current user since they contain the session ticket
+func WriteCredentials(path string, credentials Credentials) error {
+	content, err := json.MarshalIndent(credentials, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0600)
+}
+
+// command context shared by all commands
+type command struct {
+	ctx         context.Context
+	client      *client.Client
+	credentials Credentials
+	path        string
+	output      string
+	stdin       io.Reader
+	stdout      io.Writer
+	stderr      io.Writer
+}
+
+// run the ctl command with the arguments after ctl and returns the exit code
+func Run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	url := flags.String("url", "", "base url of the API, defaults to the url used at login")
+	path := flags.String("credentials", DefaultCredentialsPath(), "path to the credentials file")
+	output := flags.String("output", "table", "output format: table, json, yaml")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	if *output != "table" && *output != "json" && *output != "yaml" {
+		fmt.Fprintf(stderr, "unknown output format %s\n", *output)
+		return 2
+	}
+
+	credentials, err := ReadCredentials(*path)
+	if err != nil {
+		fmt.Fprintf(stderr, "error reading credentials: %s\n", err)
+		return 1
+	}
+	if *url != "" && *url != credentials.URL { // cookies are only valid for the url they were issued by
+		credentials = Credentials{URL: *url}
+	}
+	if credentials.URL == "" {
+		credentials.URL = "http://localhost:8082"
+	}
+	paas, err := client.New(credentials.URL)
+	if err != nil {
+		fmt.Fprintf(stderr, "error creating client: %s\n", err)
+		return 1
+	}
+	paas.SetCookies(credentials.Cookies)
+	paas.SetCSRFToken(credentials.CSRFToken)
+
+	cmd := command{
+		ctx:         context.Background(),
+		client:      paas,
+		credentials: credentials,
+		path:        *path,
+		output:      *output,
+		stdin:       stdin,
+		stdout:      stdout,
+		stderr:      stderr,
+	}
+
+	rest := flags.Args()[1:]
+	switch flags.Arg(0) {
+	case "login":
+		err = cmd.login(rest)
+	case "logout":
+		err = cmd.logout()
+	case "users":
+		err = cmd.users(rest)
+	case "groups":
+		err = cmd.groups(rest)
+	case "members":
+		err = cmd.members(rest)
+	default:
+		err = usageError{fmt.Sprintf("unknown command %s", flags.Arg(0))}
+	}
+
+	var usageErr usageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintln(stderr, usageErr.message)
+		flags.Usage()
+		return 2
+	} else if err != nil {
+		if client.IsUnauthorized(err) {
+			fmt.Fprintln(stderr, "error: not logged in or session expired, run proxmoxaas-ldap ctl login")
+		} else {
+			fmt.Fprintf(stderr, "error: %s\n", err)
+		}
+		return 1
+	}
+	return 0
+}
+
+type usageError struct {
+	message string
+}
+
+func (e usageError) Error() string {
+	return e.message
+}
+
+// parse flags which may appear before or after positional arguments and return the positional arguments
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	flags.SetOutput(io.Discard)
+	positional := []string{}
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, usageError{err.Error()}
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// returns the ids of the argument, or of each line of stdin if the argument is -
+func (cmd command) ids(arg string) ([]string, error) {
+	if arg != "-" {
+		return []string{arg}, nil
+	}
+	ids := []string{}
+	scanner := bufio.NewScanner(cmd.stdin)
+	for scanner.Scan() {
+		id := strings.TrimSpace(scanner.Text())
+		if id != "" && !strings.HasPrefix(id, "#") {
+			ids = append(ids, id)
+		}
+	}
+	return ids, scanner.Err()
+}
+
+// decode a json array or a stream of json objects from stdin into items
+func decodeBulk[T any](r io.Reader) ([]T, error) {
+	items := []T{}
+	reader := bufio.NewReader(r)
+	first, err := peekNonSpace(reader)
+	if err == io.EOF {
+		return items, nil
+	} else if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(reader)
+	if first == '[' {
+		err := decoder.Decode(&items)
+		return items, err
+	}
+	for {
+		var item T
+		err := decoder.Decode(&item)
+		if err == io.EOF {
+			return items, nil
+		} else if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(b[0])) {
+			return b[0], nil
+		}
+		_, _ = reader.ReadByte()
+	}
+}
+
+// run fn for each index and id and report the result of each, returns an error if any failed
+func (cmd command) each(ids []string, action string, fn func(i int, id string) error) error {
+	failed := 0
+	for i, id := range ids {
+		if err := fn(i, id); err != nil {
+			if client.IsUnauthorized(err) {
+				return err
+			}
+			fmt.Fprintf(cmd.stderr, "%s: %s\n", id, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(cmd.stdout, "%s %s\n", action, id)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d failed", failed, len(ids))
+	}
+	return nil
+}
+
+func (cmd command) login(args []string) error {
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	username := flags.String("username", "", "uid of the user")
+	passwordStdin := flags.Bool("password-stdin", false, "read the password from stdin instead of prompting")
+	if _, err := parseArgs(flags, args); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(cmd.stdin)
+	if *username == "" {
+		fmt.Fprint(cmd.stderr, "Username: ")
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return err
+		}
+		*username = strings.TrimSpace(line)
+	}
+	var password string
+	if file, ok := cmd.stdin.(*os.File); ok && !*passwordStdin && term.IsTerminal(int(file.Fd())) { // prompt without echoing the password
+		fmt.Fprint(cmd.stderr, "Password: ")
+		secret, err := term.ReadPassword(int(file.Fd()))
+		fmt.Fprintln(cmd.stderr)
+		if err != nil {
+			return err
+		}
+		password = string(secret)
+	} else {
+		if !*passwordStdin {
+			fmt.Fprint(cmd.stderr, "Password: ")
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return err
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	if err := cmd.client.Login(cmd.ctx, *username, password); err != nil {
+		return err
+	}
+	cmd.credentials.Cookies = cmd.client.Cookies()
+	cmd.credentials.CSRFToken = cmd.client.CSRFToken()
+	if err := WriteCredentials(cmd.path, cmd.credentials); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.stdout, "logged in to %s as %s\n", cmd.credentials.URL, *username)
+	return nil
+}
+
+func (cmd command) logout() error {
+	err := cmd.client.Logout(cmd.ctx)
+	if err != nil && !client.IsUnauthorized(err) {
+		return err
+	}
+	cmd.credentials.Cookies = nil
+	cmd.credentials.CSRFToken = ""
+	return WriteCredentials(cmd.path, cmd.credentials)
+}
+
+// user fields read from stdin for bulk create and update
+type bulkUser struct {
+	UID          string    `json:"uid"`
+	CN           string    `json:"cn"`
+	SN           string    `json:"sn"`
+	Mail         string    `json:"mail"`
+	UserPassword string    `json:"userpassword"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+func (cmd command) users(args []string) error {
+	if len(args) == 0 {
+		return usageError{"missing users command"}
+	}
+	flags := flag.NewFlagSet("users", flag.ContinueOnError)
+	user := bulkUser{}
+	flags.StringVar(&user.CN, "cn", "", "common name")
+	flags.StringVar(&user.SN, "sn", "", "surname")
+	flags.StringVar(&user.Mail, "mail", "", "mail address")
+	flags.StringVar(&user.UserPassword, "password", "", "password")
+	expiresAt := flags.String("expires-at", "", "RFC 3339 time the account expires at")
+	positional, err := parseArgs(flags, args[1:])
+	if err != nil {
+		return err
+	}
+	if *expiresAt != "" {
+		user.ExpiresAt, err = time.Parse(time.RFC3339, *expiresAt)
+		if err != nil {
+			return usageError{"--expires-at must be an RFC 3339 time"}
+		}
+	}
+
+	if args[0] == "list" {
+		users, err := cmd.client.ListUsers(cmd.ctx)
+		if err != nil {
+			return err
+		}
+		return cmd.print(users)
+	}
+	if len(positional) != 1 {
+		return usageError{fmt.Sprintf("users %s requires a uid", args[0])}
+	}
+
+	switch args[0] {
+	case "get":
+		ids, err := cmd.ids(positional[0])
+		if err != nil {
+			return err
+		}
+		users := []client.User{}
+		for _, uid := range ids {
+			user, err := cmd.client.GetUser(cmd.ctx, uid)
+			if err != nil {
+				return fmt.Errorf("%s: %w", uid, err)
+			}
+			users = append(users, user)
+		}
+		if positional[0] != "-" {
+			return cmd.print(users[0])
+		}
+		return cmd.print(users)
+	case "create", "update":
+		users := []bulkUser{}
+		if positional[0] == "-" {
+			users, err = decodeBulk[bulkUser](cmd.stdin)
+			if err != nil {
+				return err
+			}
+		} else {
+			user.UID = positional[0]
+			users = append(users, user)
+		}
+		uids := []string{}
+		for _, user := range users {
+			uids = append(uids, user.UID)
+		}
+		return cmd.each(uids, args[0]+"d user", func(i int, uid string) error {
+			user := users[i]
+			fields := client.UserFields{CN: user.CN, SN: user.SN, Mail: user.Mail, UserPassword: user.UserPassword, ExpiresAt: user.ExpiresAt}
+			if args[0] == "create" {
+				return cmd.client.CreateUser(cmd.ctx, uid, fields)
+			}
+			return cmd.client.UpdateUser(cmd.ctx, uid, fields)
+		})
+	case "delete":
+		ids, err := cmd.ids(positional[0])
+		if err != nil {
+			return err
+		}
+		return cmd.each(ids, "deleted user", func(_ int, uid string) error {
+			return cmd.client.DeleteUser(cmd.ctx, uid)
+		})
+	default:
+		return usageError{fmt.Sprintf("unknown users command %s", args[0])}
+	}
+}
+
+// group fields read from stdin for bulk create and update
+type bulkGroup struct {
+	GID         string   `json:"gid"`
+	Description string   `json:"description"`
+	Owner       []string `json:"owner"`
+	MemberURL   []string `json:"memberURL"`
+}
+
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func (cmd command) groups(args []string) error {
+	if len(args) == 0 {
+		return usageError{"missing groups command"}
+	}
+	flags := flag.NewFlagSet("groups", flag.ContinueOnError)
+	group := bulkGroup{}
+	flags.StringVar(&group.Description, "description", "", "description")
+	flags.Var((*stringsFlag)(&group.Owner), "owner", "owner dn, may be repeated")
+	flags.Var((*stringsFlag)(&group.MemberURL), "member-url", "member url of a dynamic group, may be repeated")
+	positional, err := parseArgs(flags, args[1:])
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "list" {
+		groups, err := cmd.client.ListGroups(cmd.ctx)
+		if err != nil {
+			return err
+		}
+		return cmd.print(groups)
+	}
+	if len(positional) != 1 {
+		return usageError{fmt.Sprintf("groups %s requires a gid", args[0])}
+	}
+
+	switch args[0] {
+	case "get":
+		ids, err := cmd.ids(positional[0])
+		if err != nil {
+			return err
+		}
+		groups := []client.Group{}
+		for _, gid := range ids {
+			group, err := cmd.client.GetGroup(cmd.ctx, gid)
+			if err != nil {
+				return fmt.Errorf("%s: %w", gid, err)
+			}
+			groups = append(groups, group)
+		}
+		if positional[0] != "-" {
+			return cmd.print(groups[0])
+		}
+		return cmd.print(groups)
+	case "create", "update":
+		groups := []bulkGroup{}
+		if positional[0] == "-" {
+			groups, err = decodeBulk[bulkGroup](cmd.stdin)
+			if err != nil {
+				return err
+			}
+		} else {
+			group.GID = positional[0]
+			groups = append(groups, group)
+		}
+		gids := []string{}
+		for _, group := range groups {
+			gids = append(gids, group.GID)
+		}
+		return cmd.each(gids, args[0]+"d group", func(i int, gid string) error {
+			group := groups[i]
+			fields := client.GroupFields{Description: group.Description, Owner: group.Owner, MemberURL: group.MemberURL}
+			if args[0] == "create" {
+				return cmd.client.CreateGroup(cmd.ctx, gid, fields)
+			}
+			return cmd.client.UpdateGroup(cmd.ctx, gid, fields)
+		})
+	case "delete":
+		ids, err := cmd.ids(positional[0])
+		if err != nil {
+			return err
+		}
+		return cmd.each(ids, "deleted group", func(_ int, gid string) error {
+			return cmd.client.DeleteGroup(cmd.ctx, gid)
+		})
+	default:
+		return usageError{fmt.Sprintf("unknown groups command %s", args[0])}
+	}
+}
+
+func (cmd command) members(args []string) error {
+	if len(args) == 0 {
+		return usageError{"missing members command"}
+	}
+	flags := flag.NewFlagSet("members", flag.ContinueOnError)
+	expiresAt := flags.String("expires-at", "", "RFC 3339 time the membership expires at")
+	positional, err := parseArgs(flags, args[1:])
+	if err != nil {
+		return err
+	}
+	var expiry time.Time
+	if *expiresAt != "" {
+		expiry, err = time.Parse(time.RFC3339, *expiresAt)
+		if err != nil {
+			return usageError{"--expires-at must be an RFC 3339 time"}
+		}
+	}
+
+	switch args[0] {
+	case "list":
+		if len(positional) != 1 {
+			return usageError{"members list requires a gid"}
+		}
+		group, err := cmd.client.GetGroup(cmd.ctx, positional[0])
+		if err != nil {
+			return err
+		}
+		return cmd.print(group.Attributes.Member)
+	case "add", "remove":
+		if len(positional) != 2 {
+			return usageError{fmt.Sprintf("members %s requires a gid and a uid", args[0])}
+		}
+		gid := positional[0]
+		ids, err := cmd.ids(positional[1])
+		if err != nil {
+			return err
+		}
+		if args[0] == "add" {
+			return cmd.each(ids, "added member", func(_ int, uid string) error {
+				return cmd.client.AddGroupMember(cmd.ctx, gid, uid, expiry)
+			})
+		}
+		return cmd.each(ids, "removed member", func(_ int, uid string) error {
+			return cmd.client.RemoveGroupMember(cmd.ctx, gid, uid)
+		})
+	default:
+		return usageError{fmt.Sprintf("unknown members command %s", args[0])}
+	}
+}
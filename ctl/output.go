@@ -0,0 +1,87 @@
+package ctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"proxmoxaas-ldap/client"
+
+	"github.com/goccy/go-yaml"
+)
+
+// print the value in the output format of the command
+func (cmd command) print(value any) error {
+	switch cmd.output {
+	case "json":
+		encoder := json.NewEncoder(cmd.stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case "yaml":
+		content, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		_, err = cmd.stdout.Write(content)
+		return err
+	default:
+		return printTable(cmd.stdout, value)
+	}
+}
+
+// print users and groups as a table with one row per entry, and single entries as a table of attributes
+func printTable(w io.Writer, value any) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	switch value := value.(type) {
+	case []client.User:
+		fmt.Fprintln(table, "UID\tCN\tSN\tMAIL\tGROUPS\tEXPIRES")
+		for _, user := range value {
+			a := user.Attributes
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", a.UID, a.CN, a.SN, a.Mail, strings.Join(a.MemberOf, ","), formatTime(a.ExpiresAt))
+		}
+	case client.User:
+		a := value.Attributes
+		fmt.Fprintf(table, "DN\t%s\n", value.DN)
+		fmt.Fprintf(table, "UID\t%s\n", a.UID)
+		fmt.Fprintf(table, "CN\t%s\n", a.CN)
+		fmt.Fprintf(table, "SN\t%s\n", a.SN)
+		fmt.Fprintf(table, "MAIL\t%s\n", a.Mail)
+		fmt.Fprintf(table, "GROUPS\t%s\n", strings.Join(a.MemberOf, ","))
+		fmt.Fprintf(table, "EXPIRES\t%s\n", formatTime(a.ExpiresAt))
+	case []client.Group:
+		fmt.Fprintln(table, "CN\tDESCRIPTION\tMEMBERS\tOWNER")
+		for _, group := range value {
+			a := group.Attributes
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", a.CN, a.Description, strconv.Itoa(len(a.Member)), strings.Join(a.Owner, ","))
+		}
+	case client.Group:
+		a := value.Attributes
+		fmt.Fprintf(table, "DN\t%s\n", value.DN)
+		fmt.Fprintf(table, "CN\t%s\n", a.CN)
+		fmt.Fprintf(table, "DESCRIPTION\t%s\n", a.Description)
+		fmt.Fprintf(table, "OWNER\t%s\n", strings.Join(a.Owner, ","))
+		fmt.Fprintf(table, "MEMBERURL\t%s\n", strings.Join(a.MemberURL, ","))
+		fmt.Fprintf(table, "MEMBERS\t%s\n", strings.Join(a.Member, ","))
+		for name, values := range a.Extra {
+			fmt.Fprintf(table, "%s\t%s\n", strings.ToUpper(name), strings.Join(values, ","))
+		}
+	case []string:
+		for _, line := range value {
+			fmt.Fprintln(table, line)
+		}
+	default:
+		return fmt.Errorf("cannot print %T as a table", value)
+	}
+	return table.Flush()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"os"
+
 	app "proxmoxaas-ldap/app"
+	ctl "proxmoxaas-ldap/ctl"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(ctl.Run(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+	}
 	app.Run()
 }
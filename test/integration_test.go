@@ -7,8 +7,10 @@ package tests
 import (
 	"fmt"
 	"net/http"
+	"path/filepath"
 	app "proxmoxaas-ldap/app"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-ldap/ldap/v3"
@@ -68,6 +70,9 @@ var AdminGroup = Group{
 			Member: []string{
 				fmt.Sprintf("uid=adminuser,%s", PeopleDN),
 			},
+			MemberURL:        []string{},
+			Owner:            []string{},
+			BusinessCategory: []string{},
 		},
 	},
 }
@@ -81,6 +86,9 @@ var AdminUserGroup = Group{
 			Member: []string{
 				fmt.Sprintf("uid=adminuser,%s", PeopleDN),
 			},
+			MemberURL:        []string{},
+			Owner:            []string{},
+			BusinessCategory: []string{},
 		},
 	},
 }
@@ -92,9 +100,11 @@ var SampleUserGroup = Group{
 		Attributes: app.LDAPGroupAttributes{
 			CN: "sampleuser",
 			Member: []string{
-				"",
 				fmt.Sprintf("uid=sampleuser,%s", PeopleDN),
 			},
+			MemberURL:        []string{},
+			Owner:            []string{},
+			BusinessCategory: []string{},
 		},
 	},
 }
@@ -104,8 +114,11 @@ var InvalidGroup = Group{
 	groupObj: app.LDAPGroup{
 		DN: fmt.Sprintf("cn=invalid,%s", GroupDN),
 		Attributes: app.LDAPGroupAttributes{
-			CN:     "invalid",
-			Member: []string{},
+			CN:               "invalid",
+			Member:           []string{},
+			MemberURL:        []string{},
+			Owner:            []string{},
+			BusinessCategory: []string{},
 		},
 	},
 }
@@ -833,9 +846,6 @@ func TestGetGroup_NoSuchGroup(t *testing.T) {
 	AssertLDAPError(t, "GetGroup(InvalidGroup) -> result", res["error"].(error), ldap.LDAPResultNoSuchObject)
 }
 
-// ModGroup does nothing since LDAP GroupOfNames does not store any attributes except CN and Members.
-// CN should not be changed and Members should be added or removed using the appropriate functions.
-// TODO update this when the function actually produces proper results
 func TestModGroup(t *testing.T) {
 	// create client
 	config, err := app.GetConfig("test_config.json")
@@ -847,7 +857,7 @@ func TestModGroup(t *testing.T) {
 	err = client.BindUser(AdminUser.username, AdminUser.password)
 	AssertLDAPError(t, "BindUser(AdminUser)", err, ldap.LDAPResultSuccess)
 
-	// test mod admin group as admin which should succeed
+	// test mod admin group with no changes as admin which should succeed
 	status, _ := client.ModGroup(AdminGroup.groupname, app.Group{})
 	AssertStatus(t, "ModGroup(AdminGroup -> AdminGroup) -> status", status, http.StatusOK)
 
@@ -855,6 +865,49 @@ func TestModGroup(t *testing.T) {
 	status, res := client.GetGroup(AdminGroup.groupname)
 	AssertStatus(t, "GetGroup(AdminGroup) -> status", status, http.StatusOK)
 	AssertLDAPGroupEquals(t, "GetGroup(AdminGroup) -> result", res["group"], AdminGroup.groupObj)
+
+	newGroup := app.Group{}
+
+	// create new sample user group
+	status, _ = client.AddGroup(SampleUserGroup.groupname, newGroup)
+	AssertStatus(t, "AddGroup(SampleUserGroup) -> status", status, http.StatusOK)
+
+	modification := app.Group{
+		Description:      "sample group description",
+		Owner:            []string{AdminUser.userObj.DN},
+		BusinessCategory: []string{"testing"},
+	}
+
+	// test mod sample group metadata as admin which should succeed
+	status, _ = client.ModGroup(SampleUserGroup.groupname, modification)
+	AssertStatus(t, "ModGroup(SampleUserGroup -> ModifiedGroup) -> status", status, http.StatusOK)
+
+	// try reading the update, which should return the expected updated group
+	status, res = client.GetGroup(SampleUserGroup.groupname)
+	expectedGroup := SampleUserGroup.groupObj
+	expectedGroup.Attributes.Member = []string{} // the placeholder member should be hidden
+	expectedGroup.Attributes.Description = modification.Description
+	expectedGroup.Attributes.Owner = modification.Owner
+	expectedGroup.Attributes.BusinessCategory = modification.BusinessCategory
+	AssertStatus(t, "GetGroup(ModifiedGroup) -> status", status, http.StatusOK)
+	AssertLDAPGroupEquals(t, "GetGroup(ModifiedGroup) -> result", res["group"], expectedGroup)
+
+	// clear the sample group metadata, which should succeed and keep the fields which are not cleared
+	status, _ = client.ModGroup(SampleUserGroup.groupname, app.Group{Clear: []string{"owner", "businessCategory"}})
+	AssertStatus(t, "ModGroup(SampleUserGroup -> ClearedGroup) -> status", status, http.StatusOK)
+	status, res = client.GetGroup(SampleUserGroup.groupname)
+	expectedGroup.Attributes.Owner = []string{}
+	expectedGroup.Attributes.BusinessCategory = []string{}
+	AssertStatus(t, "GetGroup(ClearedGroup) -> status", status, http.StatusOK)
+	AssertLDAPGroupEquals(t, "GetGroup(ClearedGroup) -> result", res["group"], expectedGroup)
+
+	// clearing attributes which are not set should also succeed
+	status, _ = client.ModGroup(SampleUserGroup.groupname, app.Group{Clear: []string{"description", "owner"}})
+	AssertStatus(t, "ModGroup(ClearedGroup -> ClearedGroup) -> status", status, http.StatusOK)
+
+	// delete the sample user group
+	status, _ = client.DelGroup(SampleUserGroup.groupname)
+	AssertStatus(t, "DelGroup(SampleUserGroup) -> status", status, http.StatusOK)
 }
 
 func TestModGroup_NoSuchGroup(t *testing.T) {
@@ -947,7 +1000,7 @@ func TestAddGetDelGroup(t *testing.T) {
 	// try reading the new group, which should return the expected sample group with no members
 	status, res := client.GetGroup(SampleUserGroup.groupname)
 	expectedGroup := SampleUserGroup.groupObj
-	expectedGroup.Attributes.Member = []string{""} // override the expected members since we aren't testing that here
+	expectedGroup.Attributes.Member = []string{} // the placeholder member should be hidden
 	AssertStatus(t, "GetGroup(SampleUserGroup) -> status", status, http.StatusOK)
 	AssertLDAPGroupEquals(t, "GetGroup(SampleUserGroup) -> result", res["group"], expectedGroup)
 
@@ -1159,10 +1212,16 @@ func TestAddDelUserToGroup(t *testing.T) {
 	// try reading the new group, which should return the expected sample group without any members
 	status, res = client.GetGroup(SampleUserGroup.groupname)
 	expectedGroup := SampleUserGroup.groupObj
-	expectedGroup.Attributes.Member = []string{""} // override the expected members since we aren't testing that here
+	expectedGroup.Attributes.Member = []string{} // the placeholder member should be hidden
 	AssertStatus(t, "GetGroup(SampleUserGroup) -> status", status, http.StatusOK)
 	AssertLDAPGroupEquals(t, "GetGroup(SampleUserGroup) -> result", res["group"], expectedGroup)
 
+	// try adding and removing the sample user again, which should succeed now that the group only has the placeholder
+	status, _ = client.AddUserToGroup(SampleUser.username, SampleUserGroup.groupname)
+	AssertStatus(t, "AddUserToGroup(SampleUser -> SampleUserGroup) -> status", status, http.StatusOK)
+	status, _ = client.DelUserFromGroup(SampleUser.username, SampleUserGroup.groupname)
+	AssertStatus(t, "DelUserFromGroup(SampleUser -> SampleUserGroup) -> status", status, http.StatusOK)
+
 	// delete the sample user group
 	status, _ = client.DelGroup(SampleUserGroup.groupname)
 	AssertStatus(t, "DelGroup(SampleUserGroup) -> status", status, http.StatusOK)
@@ -1235,3 +1294,301 @@ func TestDelUserFromGroup_NoSuchGroup(t *testing.T) {
 	status, _ = client.DelUser(SampleUser.username)
 	AssertStatus(t, "DelUser(SampleUser) -> status", status, http.StatusOK)
 }
+
+func TestAddDelGroupToGroup(t *testing.T) {
+	// create client
+	config, err := app.GetConfig("test_config.json")
+	AssertError(t, "GetConfig()", err, nil)
+	client, err := app.NewLDAPClient(config)
+	AssertLDAPError(t, "NewLDAPClient()", err, ldap.LDAPResultSuccess)
+
+	// bind using admin user credentials which should succeed
+	err = client.BindUser(AdminUser.username, AdminUser.password)
+	AssertLDAPError(t, "BindUser(AdminUser)", err, ldap.LDAPResultSuccess)
+
+	newUser := app.UserRequired{
+		CN:           SampleUser.userObj.Attributes.CN,
+		SN:           SampleUser.userObj.Attributes.SN,
+		Mail:         SampleUser.userObj.Attributes.Mail,
+		UserPassword: SampleUser.password,
+	}
+
+	// create new sample user, which should succeed
+	status, _ := client.AddUser(SampleUser.username, newUser)
+	AssertStatus(t, "AddUser(SampleUser) -> status", status, http.StatusOK)
+
+	childGroupname := "samplechild"
+	childGroupDN := fmt.Sprintf("cn=%s,%s", childGroupname, GroupDN)
+
+	// create the sample parent and child groups, which should succeed
+	status, _ = client.AddGroup(SampleUserGroup.groupname, app.Group{})
+	AssertStatus(t, "AddGroup(SampleUserGroup) -> status", status, http.StatusOK)
+	status, _ = client.AddGroup(childGroupname, app.Group{})
+	AssertStatus(t, "AddGroup(ChildGroup) -> status", status, http.StatusOK)
+
+	// add the sample user to the child group and the child group to the parent group, which should succeed
+	status, _ = client.AddUserToGroup(SampleUser.username, childGroupname)
+	AssertStatus(t, "AddUserToGroup(SampleUser -> ChildGroup) -> status", status, http.StatusOK)
+	status, _ = client.AddGroupToGroup(childGroupname, SampleUserGroup.groupname)
+	AssertStatus(t, "AddGroupToGroup(ChildGroup -> SampleUserGroup) -> status", status, http.StatusOK)
+
+	// try adding the parent group to the child group, which should fail with LoopDetect
+	status, res := client.AddGroupToGroup(SampleUserGroup.groupname, childGroupname)
+	AssertStatus(t, "AddGroupToGroup(SampleUserGroup -> ChildGroup) -> status", status, http.StatusBadRequest)
+	AssertLDAPError(t, "AddGroupToGroup(SampleUserGroup -> ChildGroup) -> result", res["error"].(error), ldap.LDAPResultLoopDetect)
+
+	// try adding a group to itself, which should fail with LoopDetect
+	status, res = client.AddGroupToGroup(childGroupname, childGroupname)
+	AssertStatus(t, "AddGroupToGroup(ChildGroup -> ChildGroup) -> status", status, http.StatusBadRequest)
+	AssertLDAPError(t, "AddGroupToGroup(ChildGroup -> ChildGroup) -> result", res["error"].(error), ldap.LDAPResultLoopDetect)
+
+	// try adding an invalid group, which should fail with NoSuchObject
+	status, res = client.AddGroupToGroup(InvalidGroup.groupname, SampleUserGroup.groupname)
+	AssertStatus(t, "AddGroupToGroup(InvalidGroup -> SampleUserGroup) -> status", status, http.StatusBadRequest)
+	AssertLDAPError(t, "AddGroupToGroup(InvalidGroup -> SampleUserGroup) -> result", res["error"].(error), ldap.LDAPResultNoSuchObject)
+
+	// effective members of the parent group should only contain the sample user
+	status, res = client.GetGroupEffectiveMembers(SampleUserGroup.groupname)
+	AssertStatus(t, "GetGroupEffectiveMembers(SampleUserGroup) -> status", status, http.StatusOK)
+	members := res["members"].([]string)
+	AssertEquals(t, "GetGroupEffectiveMembers(SampleUserGroup) -> len(members)", len(members), 1)
+	AssertEquals(t, "GetGroupEffectiveMembers(SampleUserGroup) -> members[0]", members[0], SampleUser.userObj.DN)
+
+	// effective groups of the sample user should contain both the child and parent groups
+	status, res = client.GetUserEffectiveGroups(SampleUser.username)
+	AssertStatus(t, "GetUserEffectiveGroups(SampleUser) -> status", status, http.StatusOK)
+	groups := res["groups"].([]string)
+	AssertEquals(t, "GetUserEffectiveGroups(SampleUser) -> len(groups)", len(groups), 2)
+	AssertEquals(t, "GetUserEffectiveGroups(SampleUser) -> groups[0]", groups[0], childGroupDN)
+	AssertEquals(t, "GetUserEffectiveGroups(SampleUser) -> groups[1]", groups[1], SampleUserGroup.groupObj.DN)
+
+	// with the in chain matching rule enabled, OpenLDAP does not support the rule so the same groups and members should be found by the iterative search
+	config.UseMatchingRuleInChain = true
+	inChainClient, err := app.NewLDAPClient(config)
+	AssertLDAPError(t, "NewLDAPClient(inChain)", err, ldap.LDAPResultSuccess)
+	err = inChainClient.BindUser(AdminUser.username, AdminUser.password)
+	AssertLDAPError(t, "BindUser(AdminUser)", err, ldap.LDAPResultSuccess)
+	status, res = inChainClient.GetUserEffectiveGroups(SampleUser.username)
+	AssertStatus(t, "GetUserEffectiveGroups(SampleUser, inChain) -> status", status, http.StatusOK)
+	AssertEquals(t, "GetUserEffectiveGroups(SampleUser, inChain) -> len(groups)", len(res["groups"].([]string)), 2)
+	status, res = inChainClient.GetGroupEffectiveMembers(SampleUserGroup.groupname)
+	AssertStatus(t, "GetGroupEffectiveMembers(SampleUserGroup, inChain) -> status", status, http.StatusOK)
+	AssertEquals(t, "GetGroupEffectiveMembers(SampleUserGroup, inChain) -> len(members)", len(res["members"].([]string)), 1)
+	inChainClient.Close()
+
+	// effective groups of an invalid user should fail with NoSuchObject instead of returning no groups
+	status, res = client.GetUserEffectiveGroups(InvalidUser.username)
+	AssertStatus(t, "GetUserEffectiveGroups(InvalidUser) -> status", status, http.StatusBadRequest)
+	AssertLDAPError(t, "GetUserEffectiveGroups(InvalidUser) -> result", res["error"].(error), ldap.LDAPResultNoSuchObject)
+
+	// remove the child group from the parent group, which should succeed
+	status, _ = client.DelGroupFromGroup(childGroupname, SampleUserGroup.groupname)
+	AssertStatus(t, "DelGroupFromGroup(ChildGroup -> SampleUserGroup) -> status", status, http.StatusOK)
+
+	// effective members of the parent group should now be empty
+	status, res = client.GetGroupEffectiveMembers(SampleUserGroup.groupname)
+	AssertStatus(t, "GetGroupEffectiveMembers(SampleUserGroup) -> status", status, http.StatusOK)
+	AssertEquals(t, "GetGroupEffectiveMembers(SampleUserGroup) -> len(members)", len(res["members"].([]string)), 0)
+
+	// delete the sample groups and user
+	status, _ = client.DelGroup(childGroupname)
+	AssertStatus(t, "DelGroup(ChildGroup) -> status", status, http.StatusOK)
+	status, _ = client.DelGroup(SampleUserGroup.groupname)
+	AssertStatus(t, "DelGroup(SampleUserGroup) -> status", status, http.StatusOK)
+	status, _ = client.DelUser(SampleUser.username)
+	AssertStatus(t, "DelUser(SampleUser) -> status", status, http.StatusOK)
+}
+
+func TestSetGroupMembers(t *testing.T) {
+	// create client
+	config, err := app.GetConfig("test_config.json")
+	AssertError(t, "GetConfig()", err, nil)
+	client, err := app.NewLDAPClient(config)
+	AssertLDAPError(t, "NewLDAPClient()", err, ldap.LDAPResultSuccess)
+
+	// bind using admin user credentials which should succeed
+	err = client.BindUser(AdminUser.username, AdminUser.password)
+	AssertLDAPError(t, "BindUser(AdminUser)", err, ldap.LDAPResultSuccess)
+
+	newUser := app.UserRequired{
+		CN:           SampleUser.userObj.Attributes.CN,
+		SN:           SampleUser.userObj.Attributes.SN,
+		Mail:         SampleUser.userObj.Attributes.Mail,
+		UserPassword: SampleUser.password,
+	}
+
+	// create new sample user and group, which should succeed
+	status, _ := client.AddUser(SampleUser.username, newUser)
+	AssertStatus(t, "AddUser(SampleUser) -> status", status, http.StatusOK)
+	status, _ = client.AddGroup(SampleUserGroup.groupname, app.Group{})
+	AssertStatus(t, "AddGroup(SampleUserGroup) -> status", status, http.StatusOK)
+
+	// set the members to the sample user, admin user, and an invalid user which should add the valid users
+	status, res := client.SetGroupMembers(SampleUserGroup.groupname, []string{SampleUser.username, AdminUser.username, InvalidUser.username})
+	AssertStatus(t, "SetGroupMembers(SampleUserGroup) -> status", status, http.StatusOK)
+	AssertEquals(t, "SetGroupMembers(SampleUserGroup) -> len(added)", len(res["added"].([]string)), 2)
+	AssertEquals(t, "SetGroupMembers(SampleUserGroup) -> len(removed)", len(res["removed"].([]string)), 0)
+	AssertEquals(t, "SetGroupMembers(SampleUserGroup) -> len(notfound)", len(res["notfound"].([]string)), 1)
+	AssertEquals(t, "SetGroupMembers(SampleUserGroup) -> notfound[0]", res["notfound"].([]string)[0], InvalidUser.username)
+
+	// set the members to only the sample user which should remove the admin user
+	status, res = client.SetGroupMembers(SampleUserGroup.groupname, []string{SampleUser.username})
+	AssertStatus(t, "SetGroupMembers(SampleUserGroup) -> status", status, http.StatusOK)
+	AssertEquals(t, "SetGroupMembers(SampleUserGroup) -> len(added)", len(res["added"].([]string)), 0)
+	AssertEquals(t, "SetGroupMembers(SampleUserGroup) -> len(removed)", len(res["removed"].([]string)), 1)
+	AssertEquals(t, "SetGroupMembers(SampleUserGroup) -> removed[0]", res["removed"].([]string)[0], AdminUser.username)
+
+	// try reading the group, which should return the expected sample group with member
+	status, res = client.GetGroup(SampleUserGroup.groupname)
+	AssertStatus(t, "GetGroup(SampleUserGroup) -> status", status, http.StatusOK)
+	AssertLDAPGroupEquals(t, "GetGroup(SampleUserGroup) -> result", res["group"], SampleUserGroup.groupObj)
+
+	// set the members to an empty list which should remove the sample user
+	status, res = client.SetGroupMembers(SampleUserGroup.groupname, []string{})
+	AssertStatus(t, "SetGroupMembers(SampleUserGroup) -> status", status, http.StatusOK)
+	AssertEquals(t, "SetGroupMembers(SampleUserGroup) -> len(removed)", len(res["removed"].([]string)), 1)
+
+	// try reading the group, which should return the expected sample group without any members
+	status, res = client.GetGroup(SampleUserGroup.groupname)
+	expectedGroup := SampleUserGroup.groupObj
+	expectedGroup.Attributes.Member = []string{}
+	AssertStatus(t, "GetGroup(SampleUserGroup) -> status", status, http.StatusOK)
+	AssertLDAPGroupEquals(t, "GetGroup(SampleUserGroup) -> result", res["group"], expectedGroup)
+
+	// try setting the members of an invalid group which should fail with NoSuchObject
+	status, res = client.SetGroupMembers(InvalidGroup.groupname, []string{SampleUser.username})
+	AssertStatus(t, "SetGroupMembers(InvalidGroup) -> status", status, http.StatusBadRequest)
+	AssertLDAPError(t, "SetGroupMembers(InvalidGroup) -> result", res["error"].(error), ldap.LDAPResultNoSuchObject)
+
+	// delete the sample group and user
+	status, _ = client.DelGroup(SampleUserGroup.groupname)
+	AssertStatus(t, "DelGroup(SampleUserGroup) -> status", status, http.StatusOK)
+	status, _ = client.DelUser(SampleUser.username)
+	AssertStatus(t, "DelUser(SampleUser) -> status", status, http.StatusOK)
+}
+
+func TestGetSetUserGroups(t *testing.T) {
+	// create client
+	config, err := app.GetConfig("test_config.json")
+	AssertError(t, "GetConfig()", err, nil)
+	client, err := app.NewLDAPClient(config)
+	AssertLDAPError(t, "NewLDAPClient()", err, ldap.LDAPResultSuccess)
+
+	// bind using admin user credentials which should succeed
+	err = client.BindUser(AdminUser.username, AdminUser.password)
+	AssertLDAPError(t, "BindUser(AdminUser)", err, ldap.LDAPResultSuccess)
+
+	// get the admin user groups which should return the group names instead of DNs
+	status, res := client.GetUserGroups(AdminUser.username)
+	AssertStatus(t, "GetUserGroups(AdminUser) -> status", status, http.StatusOK)
+	AssertEquals(t, "GetUserGroups(AdminUser) -> len(groups)", len(res["groups"].([]string)), 2)
+
+	newUser := app.UserRequired{
+		CN:           SampleUser.userObj.Attributes.CN,
+		SN:           SampleUser.userObj.Attributes.SN,
+		Mail:         SampleUser.userObj.Attributes.Mail,
+		UserPassword: SampleUser.password,
+	}
+
+	// create new sample user and group, which should succeed
+	status, _ = client.AddUser(SampleUser.username, newUser)
+	AssertStatus(t, "AddUser(SampleUser) -> status", status, http.StatusOK)
+	status, _ = client.AddGroup(SampleUserGroup.groupname, app.Group{})
+	AssertStatus(t, "AddGroup(SampleUserGroup) -> status", status, http.StatusOK)
+
+	// get the sample user groups which should be empty
+	status, res = client.GetUserGroups(SampleUser.username)
+	AssertStatus(t, "GetUserGroups(SampleUser) -> status", status, http.StatusOK)
+	AssertEquals(t, "GetUserGroups(SampleUser) -> len(groups)", len(res["groups"].([]string)), 0)
+
+	// set the sample user groups to the sample group and an invalid group which should partially fail
+	status, res = client.SetUserGroups(SampleUser.username, []string{SampleUserGroup.groupname, InvalidGroup.groupname})
+	AssertStatus(t, "SetUserGroups(SampleUser) -> status", status, http.StatusMultiStatus)
+	AssertEquals(t, "SetUserGroups(SampleUser) -> len(added)", len(res["added"].([]string)), 1)
+	AssertEquals(t, "SetUserGroups(SampleUser) -> len(failed)", len(res["failed"].([]gin.H)), 1)
+	AssertEquals(t, "SetUserGroups(SampleUser) -> failed[0]", res["failed"].([]gin.H)[0]["group"].(string), InvalidGroup.groupname)
+
+	// get the sample user groups which should contain the sample group
+	status, res = client.GetUserGroups(SampleUser.username)
+	AssertStatus(t, "GetUserGroups(SampleUser) -> status", status, http.StatusOK)
+	AssertEquals(t, "GetUserGroups(SampleUser) -> len(groups)", len(res["groups"].([]string)), 1)
+	AssertEquals(t, "GetUserGroups(SampleUser) -> groups[0]", res["groups"].([]string)[0], SampleUserGroup.groupname)
+
+	// set the sample user groups to an empty list which should remove the sample group
+	status, res = client.SetUserGroups(SampleUser.username, []string{})
+	AssertStatus(t, "SetUserGroups(SampleUser) -> status", status, http.StatusOK)
+	AssertEquals(t, "SetUserGroups(SampleUser) -> len(removed)", len(res["removed"].([]string)), 1)
+
+	// get the invalid user groups which should fail with NoSuchObject
+	status, res = client.GetUserGroups(InvalidUser.username)
+	AssertStatus(t, "GetUserGroups(InvalidUser) -> status", status, http.StatusBadRequest)
+	AssertLDAPError(t, "GetUserGroups(InvalidUser) -> result", res["error"].(error), ldap.LDAPResultNoSuchObject)
+
+	// delete the sample group and user
+	status, _ = client.DelGroup(SampleUserGroup.groupname)
+	AssertStatus(t, "DelGroup(SampleUserGroup) -> status", status, http.StatusOK)
+	status, _ = client.DelUser(SampleUser.username)
+	AssertStatus(t, "DelUser(SampleUser) -> status", status, http.StatusOK)
+}
+
+func TestSoftDelRestoreUser(t *testing.T) {
+	// create client
+	config, err := app.GetConfig("test_config.json")
+	AssertError(t, "GetConfig()", err, nil)
+	client, err := app.NewLDAPClient(config)
+	AssertLDAPError(t, "NewLDAPClient()", err, ldap.LDAPResultSuccess)
+	store, err := app.NewDeletedEntryStore(filepath.Join(t.TempDir(), "trash.json"), time.Hour)
+	AssertError(t, "NewDeletedEntryStore()", err, nil)
+
+	// bind using admin user credentials which should succeed
+	err = client.BindUser(AdminUser.username, AdminUser.password)
+	AssertLDAPError(t, "BindUser(AdminUser)", err, ldap.LDAPResultSuccess)
+
+	newUser := app.UserRequired{
+		CN:           SampleUser.userObj.Attributes.CN,
+		SN:           SampleUser.userObj.Attributes.SN,
+		Mail:         SampleUser.userObj.Attributes.Mail,
+		UserPassword: SampleUser.password,
+	}
+
+	// create new sample user and group with the sample user as a member, which should succeed
+	status, _ := client.AddUser(SampleUser.username, newUser)
+	AssertStatus(t, "AddUser(SampleUser) -> status", status, http.StatusOK)
+	status, _ = client.AddGroup(SampleUserGroup.groupname, app.Group{})
+	AssertStatus(t, "AddGroup(SampleUserGroup) -> status", status, http.StatusOK)
+	status, _ = client.AddUserToGroup(SampleUser.username, SampleUserGroup.groupname)
+	AssertStatus(t, "AddUserToGroup(SampleUser -> SampleUserGroup) -> status", status, http.StatusOK)
+
+	// soft delete the sample user which should succeed
+	status, _ = client.SoftDelUser(SampleUser.username, store)
+	AssertStatus(t, "SoftDelUser(SampleUser) -> status", status, http.StatusOK)
+
+	// try reading the sample user, which should fail since it is in the trash
+	status, res := client.GetUser(SampleUser.username)
+	AssertStatus(t, "GetUser(SampleUser) -> status", status, http.StatusBadRequest)
+	AssertLDAPError(t, "GetUser(SampleUser) -> result", res["error"].(error), ldap.LDAPResultNoSuchObject)
+
+	// the deleted user should be listed in the trash
+	status, res = client.GetDeletedEntries(store)
+	AssertStatus(t, "GetDeletedEntries() -> status", status, http.StatusOK)
+	AssertEquals(t, "GetDeletedEntries() -> len(entries)", len(res["entries"].([]gin.H)), 1)
+
+	// restore the sample user which should succeed and restore the group membership
+	status, _ = client.RestoreUser(SampleUser.username, store)
+	AssertStatus(t, "RestoreUser(SampleUser) -> status", status, http.StatusOK)
+
+	status, res = client.GetGroup(SampleUserGroup.groupname)
+	AssertStatus(t, "GetGroup(SampleUserGroup) -> status", status, http.StatusOK)
+	AssertLDAPGroupEquals(t, "GetGroup(SampleUserGroup) -> result", res["group"], SampleUserGroup.groupObj)
+
+	// try restoring the sample user again, which should fail with NoSuchObject
+	status, res = client.RestoreUser(SampleUser.username, store)
+	AssertStatus(t, "RestoreUser(SampleUser) -> status", status, http.StatusBadRequest)
+	AssertLDAPError(t, "RestoreUser(SampleUser) -> result", res["error"].(error), ldap.LDAPResultNoSuchObject)
+
+	// delete the sample group and user
+	status, _ = client.DelGroup(SampleUserGroup.groupname)
+	AssertStatus(t, "DelGroup(SampleUserGroup) -> status", status, http.StatusOK)
+	status, _ = client.DelUser(SampleUser.username)
+	AssertStatus(t, "DelUser(SampleUser) -> status", status, http.StatusOK)
+}
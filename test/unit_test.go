@@ -1,13 +1,40 @@
 package tests
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	app "proxmoxaas-ldap/app"
+	client "proxmoxaas-ldap/client"
+	ctl "proxmoxaas-ldap/ctl"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	"github.com/go-ldap/ldap/v3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // test the GetConfig utility function because it used in other tests
@@ -77,15 +104,33 @@ func TestLDAPGroupDataPipeline(t *testing.T) {
 		member = append(member, RandDN(16))
 	}
 
+	var owner []string
+	for i := 0; i < RandInt(1, 5); i++ {
+		owner = append(owner, RandDN(16))
+	}
+
 	expectedGroup := app.LDAPGroup{
 		DN: RandDN(16),
 		Attributes: app.LDAPGroupAttributes{
-			Member: member,
+			CN:               RandString(16),
+			Member:           member,
+			MemberURL:        []string{},
+			Description:      RandString(16),
+			Owner:            owner,
+			BusinessCategory: []string{RandString(16)},
+			Extra: map[string][]string{
+				"seeAlso": {RandDN(16)},
+			},
 		},
 	}
 
 	attributes := make(map[string][]string)
+	attributes["cn"] = []string{expectedGroup.Attributes.CN}
 	attributes["member"] = expectedGroup.Attributes.Member
+	attributes["description"] = []string{expectedGroup.Attributes.Description}
+	attributes["owner"] = expectedGroup.Attributes.Owner
+	attributes["businessCategory"] = expectedGroup.Attributes.BusinessCategory
+	attributes["seeAlso"] = expectedGroup.Attributes.Extra["seeAlso"]
 
 	entry := ldap.NewEntry(expectedGroup.DN, attributes)
 
@@ -123,3 +168,1389 @@ func TestHandleResponse(t *testing.T) {
 	AssertEquals(t, `HandleResponse(res)["satus"]`, handledResponse["status"].(int), res["status"].(int))
 	AssertEquals(t, `HandleResponse(res)["error"]`, handledResponse["error"], nil)
 }
+
+func TestParseMemberURL(t *testing.T) {
+	memberURL, err := app.ParseMemberURL("ldap:///ou=people,dc=test,dc=paasldap??sub?(departmentNumber=42)")
+	AssertError(t, "ParseMemberURL(sub)", err, nil)
+	AssertEquals(t, "ParseMemberURL(sub).BaseDN", memberURL.BaseDN, "ou=people,dc=test,dc=paasldap")
+	AssertEquals(t, "ParseMemberURL(sub).Scope", memberURL.Scope, ldap.ScopeWholeSubtree)
+	AssertEquals(t, "ParseMemberURL(sub).Filter", memberURL.Filter, "(departmentNumber=42)")
+
+	memberURL, err = app.ParseMemberURL("ldap:///uid=adminuser,ou=people,dc=test,dc=paasldap")
+	AssertError(t, "ParseMemberURL(default)", err, nil)
+	AssertEquals(t, "ParseMemberURL(default).BaseDN", memberURL.BaseDN, "uid=adminuser,ou=people,dc=test,dc=paasldap")
+	AssertEquals(t, "ParseMemberURL(default).Scope", memberURL.Scope, ldap.ScopeBaseObject)
+	AssertEquals(t, "ParseMemberURL(default).Filter", memberURL.Filter, "(objectClass=*)")
+
+	memberURL, err = app.ParseMemberURL("ldap:///ou=people,dc=test,dc=paasldap??one?(%26(objectClass=inetOrgPerson)(cn=a%20b))")
+	AssertError(t, "ParseMemberURL(escaped)", err, nil)
+	AssertEquals(t, "ParseMemberURL(escaped).Scope", memberURL.Scope, ldap.ScopeSingleLevel)
+	AssertEquals(t, "ParseMemberURL(escaped).Filter", memberURL.Filter, "(&(objectClass=inetOrgPerson)(cn=a b))")
+
+	_, err = app.ParseMemberURL("http:///ou=people,dc=test,dc=paasldap??sub?(uid=*)")
+	AssertEquals(t, "ParseMemberURL(scheme) -> err != nil", err != nil, true)
+
+	_, err = app.ParseMemberURL("ldap:///ou=people,dc=test,dc=paasldap??tree?(uid=*)")
+	AssertEquals(t, "ParseMemberURL(scope) -> err != nil", err != nil, true)
+
+	_, err = app.ParseMemberURL("ldap:///ou=people,dc=test,dc=paasldap??sub?(uid=*")
+	AssertEquals(t, "ParseMemberURL(filter) -> err != nil", err != nil, true)
+}
+
+func TestMembershipExpiryStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "expiries.json")
+	store, err := app.NewMembershipExpiryStore(path)
+	AssertError(t, "NewMembershipExpiryStore()", err, nil)
+
+	groupDN := RandDN(16)
+	memberDN := RandDN(16)
+	otherDN := RandDN(16)
+	now := time.Now()
+
+	err = store.Set(groupDN, memberDN, now.Add(-time.Minute))
+	AssertError(t, "Set(groupDN, memberDN)", err, nil)
+	err = store.Set(groupDN, otherDN, now.Add(time.Hour))
+	AssertError(t, "Set(groupDN, otherDN)", err, nil)
+
+	AssertEquals(t, "len(ForGroup(groupDN))", len(store.ForGroup(groupDN)), 2)
+	AssertEquals(t, "len(ForMember(memberDN))", len(store.ForMember(memberDN)), 1)
+
+	expired := store.Expired(now)
+	AssertEquals(t, "len(Expired(now))", len(expired), 1)
+	AssertEquals(t, "Expired(now)[0].MemberDN", expired[0].MemberDN, memberDN)
+
+	// reload the store from the file which should contain the same expiries
+	store, err = app.NewMembershipExpiryStore(path)
+	AssertError(t, "NewMembershipExpiryStore()", err, nil)
+	AssertEquals(t, "len(ForGroup(groupDN))", len(store.ForGroup(groupDN)), 2)
+	AssertEquals(t, "ForGroup(groupDN)[otherDN]", store.ForGroup(groupDN)[otherDN].Equal(now.Add(time.Hour)), true)
+
+	err = store.Delete(groupDN, memberDN)
+	AssertError(t, "Delete(groupDN, memberDN)", err, nil)
+	AssertEquals(t, "len(Expired(now))", len(store.Expired(now)), 0)
+
+	err = store.DeleteDN(groupDN)
+	AssertError(t, "DeleteDN(groupDN)", err, nil)
+	AssertEquals(t, "len(ForGroup(groupDN))", len(store.ForGroup(groupDN)), 0)
+}
+
+func TestAccountExpiryFormat(t *testing.T) {
+	expiresAt := time.Date(2030, time.March, 4, 5, 6, 7, 0, time.UTC)
+
+	value := app.FormatExpiry("pwdEndTime", expiresAt)
+	AssertEquals(t, `FormatExpiry("pwdEndTime")`, value, "20300304050607Z")
+	parsed, err := app.ParseExpiry("pwdEndTime", value)
+	AssertError(t, `ParseExpiry("pwdEndTime")`, err, nil)
+	AssertEquals(t, `ParseExpiry("pwdEndTime")`, *parsed, expiresAt)
+
+	parsed, err = app.ParseExpiry("pwdEndTime", "20300304050607.123Z")
+	AssertError(t, `ParseExpiry("pwdEndTime") fractional`, err, nil)
+	AssertEquals(t, `ParseExpiry("pwdEndTime") fractional`, parsed.Truncate(time.Second), expiresAt)
+
+	value = app.FormatExpiry("shadowExpire", expiresAt) // rounded up to the next midnight
+	AssertEquals(t, `FormatExpiry("shadowExpire")`, value, fmt.Sprintf("%d", expiresAt.Unix()/86400+1))
+	parsed, err = app.ParseExpiry("shadowExpire", value)
+	AssertError(t, `ParseExpiry("shadowExpire")`, err, nil)
+	AssertEquals(t, `ParseExpiry("shadowExpire")`, *parsed, time.Date(2030, time.March, 5, 0, 0, 0, 0, time.UTC))
+
+	midnight := time.Date(2030, time.March, 4, 0, 0, 0, 0, time.UTC)
+	AssertEquals(t, `FormatExpiry("shadowExpire") midnight`, app.FormatExpiry("shadowExpire", midnight), fmt.Sprintf("%d", midnight.Unix()/86400))
+
+	parsed, err = app.ParseExpiry("shadowExpire", "-1")
+	AssertError(t, `ParseExpiry("shadowExpire") never`, err, nil)
+	AssertEquals(t, `ParseExpiry("shadowExpire") never`, parsed == nil, true)
+
+	_, err = app.ParseExpiry("pwdEndTime", RandString(16))
+	AssertEquals(t, `ParseExpiry("pwdEndTime") invalid -> err != nil`, err != nil, true)
+}
+
+func TestDeletedEntryStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trash.json")
+	store, err := app.NewDeletedEntryStore(path, 24*time.Hour)
+	AssertError(t, "NewDeletedEntryStore()", err, nil)
+
+	now := time.Now()
+	oldEntry := app.DeletedEntry{
+		Type:        "user",
+		ID:          RandString(16),
+		OriginalDN:  RandDN(16),
+		TrashDN:     RandDN(16),
+		DeletedAt:   now.Add(-48 * time.Hour),
+		Memberships: []string{RandDN(16)},
+	}
+	newEntry := app.DeletedEntry{
+		Type:      "group",
+		ID:        RandString(16),
+		TrashDN:   RandDN(16),
+		DeletedAt: now,
+	}
+
+	AssertError(t, "Add(oldEntry)", store.Add(oldEntry), nil)
+	AssertError(t, "Add(newEntry)", store.Add(newEntry), nil)
+
+	// an id deleted again after it was recreated is kept as a separate entry
+	recreatedEntry := oldEntry
+	recreatedEntry.TrashDN = RandDN(16)
+	recreatedEntry.DeletedAt = now.Add(-time.Hour)
+	AssertError(t, "Add(recreatedEntry)", store.Add(recreatedEntry), nil)
+	entry, ok := store.Get("user", oldEntry.ID)
+	AssertEquals(t, "Get(user, oldEntry.ID) -> ok", ok, true)
+	AssertEquals(t, "Get(user, oldEntry.ID).TrashDN", entry.TrashDN, recreatedEntry.TrashDN)
+	AssertError(t, "Delete(recreatedEntry.TrashDN)", store.Delete(recreatedEntry.TrashDN), nil)
+
+	_, ok = store.Get("group", oldEntry.ID)
+	AssertEquals(t, "Get(group, oldEntry.ID) -> ok", ok, false)
+	entry, ok = store.Get("user", oldEntry.ID)
+	AssertEquals(t, "Get(user, oldEntry.ID) -> ok", ok, true)
+	AssertEquals(t, "Get(user, oldEntry.ID).TrashDN", entry.TrashDN, oldEntry.TrashDN)
+	AssertEquals(t, "RestoreUntil(newEntry)", store.RestoreUntil(newEntry).Equal(now.Add(24*time.Hour)), true)
+
+	expired := store.Expired(now)
+	AssertEquals(t, "len(Expired(now))", len(expired), 1)
+	AssertEquals(t, "Expired(now)[0].ID", expired[0].ID, oldEntry.ID)
+
+	// reload the store from the file which should contain the same entries
+	store, err = app.NewDeletedEntryStore(path, 24*time.Hour)
+	AssertError(t, "NewDeletedEntryStore()", err, nil)
+	AssertEquals(t, "len(List())", len(store.List()), 2)
+
+	AssertError(t, "Delete(oldEntry.TrashDN)", store.Delete(oldEntry.TrashDN), nil)
+	AssertEquals(t, "len(List())", len(store.List()), 1)
+}
+
+func TestAuditLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := app.NewAuditLogger(path, false)
+	AssertError(t, "NewAuditLogger()", err, nil)
+
+	now := time.Now().UTC()
+	actor := RandDN(16)
+	events := []app.AuditEvent{
+		{Time: now.Add(-2 * time.Hour), Actor: actor, Action: "user.create", Target: RandDN(16), Attributes: []string{"cn", "userPassword"}},
+		{Time: now.Add(-time.Hour), Actor: RandDN(16), Action: "group.delete", Target: RandDN(16), Attributes: []string{}},
+		{Time: now, Actor: actor, Action: "user.delete", Target: RandDN(16), Attributes: []string{}},
+		{Time: now, Actor: RandDN(16), Action: "group.member_added", Target: RandDN(16), Member: RandDN(16), Attributes: []string{"member"}},
+	}
+	for _, event := range events {
+		AssertError(t, "Write()", logger.Write(event), nil)
+	}
+
+	result, err := logger.Read(app.AuditQuery{})
+	AssertError(t, "Read({})", err, nil)
+	AssertEquals(t, "len(Read({}))", len(result), 4)
+	AssertEquals(t, "Read({})[0].Attributes", strings.Join(result[0].Attributes, ","), "cn,userPassword")
+
+	result, err = logger.Read(app.AuditQuery{Actor: actor})
+	AssertError(t, "Read({Actor})", err, nil)
+	AssertEquals(t, "len(Read({Actor}))", len(result), 2)
+
+	result, err = logger.Read(app.AuditQuery{Target: events[1].Target})
+	AssertError(t, "Read({Target})", err, nil)
+	AssertEquals(t, "len(Read({Target}))", len(result), 1)
+	AssertEquals(t, "Read({Target})[0].Action", result[0].Action, "group.delete")
+
+	result, err = logger.Read(app.AuditQuery{Member: events[3].Member})
+	AssertError(t, "Read({Member})", err, nil)
+	AssertEquals(t, "len(Read({Member}))", len(result), 1)
+	AssertEquals(t, "Read({Member})[0].Target", result[0].Target, events[3].Target)
+
+	result, err = logger.Read(app.AuditQuery{Actor: actor, Since: now.Add(-90 * time.Minute)})
+	AssertError(t, "Read({Actor, Since})", err, nil)
+	AssertEquals(t, "len(Read({Actor, Since}))", len(result), 1)
+	AssertEquals(t, "Read({Actor, Since})[0].Action", result[0].Action, "user.delete")
+
+	result, err = logger.Read(app.AuditQuery{Until: now.Add(-30 * time.Minute)})
+	AssertError(t, "Read({Until})", err, nil)
+	AssertEquals(t, "len(Read({Until}))", len(result), 2)
+}
+
+func TestWebhookQueue(t *testing.T) {
+	secret := RandString(32)
+	received := make(chan app.WebhookEvent, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-PAASLDAP-Signature") != "sha256="+app.SignWebhook(secret, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var event app.WebhookEvent
+		_ = json.Unmarshal(body, &event)
+		received <- event
+	}))
+	defer receiver.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	subscribers := []app.WebhookSubscriber{
+		{URL: receiver.URL, Secret: secret, Events: []string{"user.created"}},
+		{URL: failing.URL, Secret: RandString(32)},
+	}
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	queue, err := app.NewWebhookQueue(path, subscribers, 2, time.Second, time.Hour)
+	AssertError(t, "NewWebhookQueue()", err, nil)
+
+	now := time.Now().UTC()
+	event := app.WebhookEvent{ID: RandString(16), Type: "user.created", Time: now, Target: RandDN(16)}
+	AssertError(t, "Enqueue(user.created)", queue.Enqueue(event), nil)
+	AssertError(t, "Enqueue(user.deleted)", queue.Enqueue(app.WebhookEvent{ID: RandString(16), Type: "user.deleted", Time: now}), nil)
+	AssertEquals(t, "len(List(pending))", len(queue.List(app.WebhookPending)), 3)
+
+	app.DeliverWebhooks(queue, receiver.Client(), now)
+	select {
+	case got := <-received:
+		AssertEquals(t, "received.ID", got.ID, event.ID)
+		AssertEquals(t, "received.Target", got.Target, event.Target)
+	default:
+		t.Errorf("receiver did not receive user.created")
+	}
+	AssertEquals(t, "len(List(delivered))", len(queue.List(app.WebhookDelivered)), 1)
+	AssertEquals(t, "len(List(pending))", len(queue.List(app.WebhookPending)), 2)
+	AssertEquals(t, "len(Due(now))", len(queue.Due(now)), 0) // failed deliveries are retried after the backoff
+
+	AssertEquals(t, "Backoff(1)", queue.Backoff(1), time.Second)
+	AssertEquals(t, "Backoff(3)", queue.Backoff(3), 4*time.Second)
+	AssertEquals(t, "Backoff(100)", queue.Backoff(100), time.Hour)
+
+	// reload the queue from the file which should contain the same deliveries
+	queue, err = app.NewWebhookQueue(path, subscribers, 2, time.Second, time.Hour)
+	AssertError(t, "NewWebhookQueue()", err, nil)
+	AssertEquals(t, "len(List())", len(queue.List("")), 3)
+
+	app.DeliverWebhooks(queue, failing.Client(), time.Now().Add(time.Minute))
+	failed := queue.List(app.WebhookFailed)
+	AssertEquals(t, "len(List(failed))", len(failed), 2)
+	AssertEquals(t, "List(failed)[0].Attempts", failed[0].Attempts, 2)
+	AssertEquals(t, "List(failed)[0].LastStatus", failed[0].LastStatus, http.StatusInternalServerError)
+
+	AssertError(t, "Prune()", queue.Prune(time.Now().Add(2*time.Hour)), nil)
+	AssertEquals(t, "len(List())", len(queue.List("")), 0)
+
+	// deliveries are signed with the secret of the subscriber when they were queued
+	event = app.WebhookEvent{ID: RandString(16), Type: "user.created", Time: time.Now().UTC()}
+	AssertError(t, "Enqueue(user.created)", queue.Enqueue(event), nil)
+	AssertEquals(t, "List()[0].Secret", queue.List("")[0].Secret, "")
+	queue, err = app.NewWebhookQueue(path, []app.WebhookSubscriber{{URL: receiver.URL, Secret: RandString(32)}}, 2, time.Second, time.Hour)
+	AssertError(t, "NewWebhookQueue()", err, nil)
+	app.DeliverWebhooks(queue, receiver.Client(), time.Now())
+	select {
+	case got := <-received:
+		AssertEquals(t, "received.ID", got.ID, event.ID)
+	default:
+		t.Errorf("receiver did not receive user.created signed with the queued secret")
+	}
+}
+
+func TestSyncEvents(t *testing.T) {
+	peopledn := "ou=people,dc=example,dc=com"
+	groupsdn := "ou=groups,dc=example,dc=com"
+
+	user := ldap.NewEntry("uid=test,"+peopledn, map[string][]string{"objectClass": {"inetOrgPerson"}})
+	group := ldap.NewEntry("cn=test,"+groupsdn, map[string][]string{"objectClass": {"groupOfURLs"}})
+	deletedUser := ldap.NewEntry("uid=test,"+peopledn, nil)
+	deletedGroup := ldap.NewEntry("cn=test,"+groupsdn, nil)
+	other := ldap.NewEntry("ou=trash,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}})
+
+	AssertEquals(t, "SyncEntryKind(user)", app.SyncEntryKind(user, peopledn, groupsdn), "user")
+	AssertEquals(t, "SyncEntryKind(group)", app.SyncEntryKind(group, peopledn, groupsdn), "group")
+	AssertEquals(t, "SyncEntryKind(deletedUser)", app.SyncEntryKind(deletedUser, peopledn, groupsdn), "user")
+	AssertEquals(t, "SyncEntryKind(deletedGroup)", app.SyncEntryKind(deletedGroup, peopledn, groupsdn), "group")
+	AssertEquals(t, "SyncEntryKind(other)", app.SyncEntryKind(other, peopledn, groupsdn), "")
+
+	AssertEquals(t, "SyncEventType(Add)", app.SyncEventType("user", ldap.SyncStateAdd), "user.created")
+	AssertEquals(t, "SyncEventType(Modify)", app.SyncEventType("group", ldap.SyncStateModify), "group.updated")
+	AssertEquals(t, "SyncEventType(Delete)", app.SyncEventType("user", ldap.SyncStateDelete), "user.deleted")
+	AssertEquals(t, "SyncEventType(Present)", app.SyncEventType("group", ldap.SyncStatePresent), "group.present")
+
+	cookie := []byte("rid=000,csn=20260101000000.000000Z#000000#000#000000")
+	decoded, err := app.DecodeSyncCookie(app.EncodeSyncCookie(cookie))
+	AssertError(t, "DecodeSyncCookie()", err, nil)
+	AssertEquals(t, "DecodeSyncCookie(EncodeSyncCookie())", string(decoded), string(cookie))
+	decoded, err = app.DecodeSyncCookie("")
+	AssertError(t, "DecodeSyncCookie(\"\")", err, nil)
+	AssertEquals(t, "DecodeSyncCookie(\"\") == nil", decoded == nil, true)
+
+	var stream strings.Builder
+	AssertError(t, "WriteSSE(user.deleted)", app.WriteSSE(&stream, app.DirectoryEvent{Type: "user.deleted", DN: deletedUser.DN, Cookie: cookie}), nil)
+	AssertError(t, "WriteSSE(sync.ready)", app.WriteSSE(&stream, app.DirectoryEvent{Type: "sync.ready"}), nil)
+	expected := fmt.Sprintf("id: %s\nevent: user.deleted\ndata: {\"dn\":\"%s\",\"entry\":null}\n\n", app.EncodeSyncCookie(cookie), deletedUser.DN)
+	expected += "event: sync.ready\ndata: {\"dn\":\"\",\"entry\":null}\n\n"
+	AssertEquals(t, "WriteSSE()", stream.String(), expected)
+}
+
+func TestMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(app.MetricsMiddleware())
+	router.GET("/users/:userid", func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, gin.H{})
+	})
+
+	before := testutil.ToFloat64(app.HTTPRequests.WithLabelValues("GET", "/users/:userid", "404"))
+	for range 3 {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/"+RandString(8), nil))
+	}
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/"+RandString(8), nil))
+	AssertEquals(t, "HTTPRequests(/users/:userid)", testutil.ToFloat64(app.HTTPRequests.WithLabelValues("GET", "/users/:userid", "404")), before+3)
+	AssertEquals(t, "HTTPRequests(unmatched) > 0", testutil.ToFloat64(app.HTTPRequests.WithLabelValues("GET", "unmatched", "404")) > 0, true)
+
+	app.LDAPSessions = map[string]*app.LDAPClient{"uuid": {}}
+	AssertEquals(t, "ActiveSessions", testutil.ToFloat64(app.ActiveSessions), float64(1))
+	app.LDAPSessions = make(map[string]*app.LDAPClient)
+
+	recorder := httptest.NewRecorder()
+	app.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	AssertStatus(t, "Status", recorder.Code, http.StatusOK)
+	for _, line := range []string{
+		"# TYPE paasldap_http_request_duration_seconds histogram",
+		`paasldap_http_requests_total{method="GET",route="/users/:userid",status="404"}`,
+		`paasldap_http_request_duration_seconds_bucket{method="GET",route="/users/:userid",le="+Inf"}`,
+		"paasldap_active_sessions 0",
+		"paasldap_ldap_dial_errors_total 0",
+	} {
+		AssertEquals(t, fmt.Sprintf("/metrics contains %s", line), strings.Contains(recorder.Body.String(), line), true)
+	}
+}
+
+func TestLogging(t *testing.T) {
+	_, err := app.NewLogger(io.Discard, "verbose", "json")
+	AssertEquals(t, "NewLogger(verbose) error", err != nil, true)
+	_, err = app.NewLogger(io.Discard, "info", "xml")
+	AssertEquals(t, "NewLogger(xml) error", err != nil, true)
+
+	var output strings.Builder
+	logger, err := app.NewLogger(&output, "info", "json")
+	AssertError(t, "NewLogger(info, json)", err, nil)
+	password := RandString(16)
+	logger.Debug("hidden")
+	logger.Info("login", "username", "test", "password", password, "UserPassword", password)
+
+	var line map[string]any
+	AssertError(t, "json.Unmarshal()", json.Unmarshal([]byte(output.String()), &line), nil)
+	AssertEquals(t, "line.username", line["username"], any("test"))
+	AssertEquals(t, "line.password", line["password"], any("[REDACTED]"))
+	AssertEquals(t, "line.UserPassword", line["UserPassword"], any("[REDACTED]"))
+	AssertEquals(t, "output contains password", strings.Contains(output.String(), password), false)
+
+	query := app.RedactQuery("cn=test&userpassword=" + password)
+	AssertEquals(t, "RedactQuery() contains password", strings.Contains(query, password), false)
+	AssertEquals(t, "RedactQuery() contains cn", strings.Contains(query, "cn=test"), true)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(app.RequestIDMiddleware())
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString(app.RequestIDKey))
+	})
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set(app.RequestIDHeader, "test-request-id")
+	router.ServeHTTP(recorder, request)
+	AssertEquals(t, "X-Request-ID (provided)", recorder.Header().Get(app.RequestIDHeader), "test-request-id")
+	AssertEquals(t, "context request ID (provided)", recorder.Body.String(), "test-request-id")
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	AssertEquals(t, "X-Request-ID (generated) != \"\"", recorder.Header().Get(app.RequestIDHeader) != "", true)
+	AssertEquals(t, "context request ID (generated)", recorder.Body.String(), recorder.Header().Get(app.RequestIDHeader))
+
+	// json error bodies also carry the request ID
+	router.GET("/unauthorized", func(c *gin.Context) {
+		c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+	})
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/unauthorized", nil)
+	request.Header.Set(app.RequestIDHeader, "test-request-id")
+	router.ServeHTTP(recorder, request)
+	var body map[string]any
+	AssertError(t, "json.Unmarshal()", json.Unmarshal(recorder.Body.Bytes(), &body), nil)
+	AssertStatus(t, "GET /unauthorized", recorder.Code, http.StatusUnauthorized)
+	AssertEquals(t, "body.requestID", body[app.RequestIDKey], any("test-request-id"))
+	AssertEquals(t, "body.auth", body["auth"], any(false))
+}
+
+func TestTracing(t *testing.T) {
+	config, err := app.GetConfig("test_config.json")
+	AssertError(t, "GetConfig()", err, nil)
+	exporter := tracetest.NewInMemoryExporter()
+	provider := app.NewTracerProvider(config, exporter)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(app.TracingMiddleware(provider))
+	router.GET("/users/:userid", func(c *gin.Context) {
+		_, span := provider.Tracer("test").Start(c.Request.Context(), "ldap.search", trace.WithSpanKind(trace.SpanKindClient))
+		span.SetAttributes(attribute.Int("ldap.entries", 1))
+		span.End()
+		c.JSON(http.StatusOK, gin.H{})
+	})
+	serve := func(traceparent string) tracetest.SpanStubs {
+		exporter.Reset()
+		request := httptest.NewRequest("GET", "/users/test", nil)
+		if traceparent != "" {
+			request.Header.Set("traceparent", traceparent)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), request)
+		AssertError(t, "ForceFlush()", provider.ForceFlush(t.Context()), nil)
+		return exporter.GetSpans()
+	}
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	spans := serve("00-" + traceID + "-00f067aa0ba902b7-01")
+	AssertEquals(t, "len(spans)", len(spans), 2)
+	child, server := spans[0], spans[1] // the child span finishes first
+	AssertEquals(t, "server.Name", server.Name, "GET /users/:userid")
+	AssertEquals(t, "server.SpanKind", server.SpanKind, trace.SpanKindServer)
+	AssertEquals(t, "server.TraceID", server.SpanContext.TraceID().String(), traceID)
+	AssertEquals(t, "server.Parent", server.Parent.SpanID().String(), "00f067aa0ba902b7")
+	AssertEquals(t, "server.Resource service.name", server.Resource.String(), "service.name=proxmoxaas-ldap")
+	AssertEquals(t, "child.Name", child.Name, "ldap.search")
+	AssertEquals(t, "child.TraceID", child.SpanContext.TraceID().String(), traceID)
+	AssertEquals(t, "child.Parent", child.Parent.SpanID(), server.SpanContext.SpanID())
+	AssertEquals(t, "child.Attributes[0]", child.Attributes[0], attribute.Int("ldap.entries", 1))
+
+	spans = serve("00-" + traceID + "-00f067aa0ba902b7-00") // the caller did not sample the trace
+	AssertEquals(t, "len(spans) not sampled", len(spans), 0)
+	spans = serve("00-00000000000000000000000000000000-00f067aa0ba902b7-01") // invalid trace IDs start a new trace
+	AssertEquals(t, "len(spans) invalid traceparent", len(spans), 2)
+	AssertEquals(t, "server.TraceID invalid traceparent", spans[1].SpanContext.TraceID().IsValid(), true)
+	AssertEquals(t, "server.Parent invalid traceparent", spans[1].Parent.IsValid(), false)
+	AssertError(t, "Shutdown()", provider.Shutdown(t.Context()), nil)
+
+	ratio := 0.0
+	config.Tracing.SampleRatio = &ratio
+	provider = app.NewTracerProvider(config, exporter)
+	router = gin.New()
+	router.Use(app.TracingMiddleware(provider))
+	router.GET("/users/:userid", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{}) })
+	AssertEquals(t, "len(spans) sampleRatio 0", len(serve("")), 0)
+	AssertEquals(t, "len(spans) sampleRatio 0 with sampled parent", len(serve("00-"+traceID+"-00f067aa0ba902b7-01")), 1)
+	AssertError(t, "Shutdown()", provider.Shutdown(t.Context()), nil)
+	config.Tracing.SampleRatio = nil
+
+	received := make(chan string, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		received <- r.URL.Path + " " + r.Header.Get("Content-Type")
+	}))
+	defer collector.Close()
+	config.Tracing.Exporter = "otlp"
+	config.Tracing.Endpoint = collector.URL + "/v1/traces"
+	otlpExporter, err := app.NewSpanExporter(t.Context(), config, nil)
+	AssertError(t, "NewSpanExporter(otlp)", err, nil)
+	provider = app.NewTracerProvider(config, otlpExporter)
+	_, span := provider.Tracer("test").Start(t.Context(), "test")
+	span.End()
+	AssertError(t, "Shutdown() exports queued spans", provider.Shutdown(t.Context()), nil)
+	select {
+	case request := <-received:
+		AssertEquals(t, "collector request", request, "/v1/traces application/x-protobuf")
+	default:
+		t.Fatalf("collector did not receive spans")
+	}
+
+	var output strings.Builder
+	config.Tracing.Exporter = "stdout"
+	stdoutExporter, err := app.NewSpanExporter(t.Context(), config, &output)
+	AssertError(t, "NewSpanExporter(stdout)", err, nil)
+	provider = app.NewTracerProvider(config, stdoutExporter)
+	_, span = provider.Tracer("test").Start(t.Context(), "test")
+	span.End()
+	AssertError(t, "Shutdown()", provider.Shutdown(t.Context()), nil)
+	AssertEquals(t, "stdout contains span", strings.Contains(output.String(), `"Name":"test"`), true)
+
+	config.Tracing.Exporter = ""
+	disabled, err := app.NewSpanExporter(t.Context(), config, nil)
+	AssertEquals(t, "NewSpanExporter() (disabled)", disabled == nil && err == nil, true)
+}
+
+func TestOpenAPI(t *testing.T) {
+	document, err := app.LoadOpenAPI()
+	AssertError(t, "LoadOpenAPI()", err, nil)
+	AssertEquals(t, "info.version", document.Info.Version, app.APIVersion)
+
+	// every registered route must be described, and every described operation must be registered
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	config := app.Config{Metrics: true}
+	app.RegisterRoutes(router, config)
+	registered := map[string]bool{}
+	for _, route := range router.Routes() {
+		registered[strings.ToLower(route.Method)+" "+app.OpenAPIPath(route.Path)] = true
+		AssertEquals(t, fmt.Sprintf("%s %s is described", route.Method, route.Path), document.Operation(route.Method, route.Path) != nil, true)
+	}
+	for path, operations := range document.Paths {
+		for method := range operations {
+			AssertEquals(t, fmt.Sprintf("%s %s is registered", method, path), registered[method+" "+path], true)
+		}
+	}
+
+	// responses are validated against the schema of their status including referenced responses and schemas
+	op := document.Operation("GET", "/users/:userid")
+	responses := []struct {
+		label  string
+		status int
+		body   string
+		valid  bool
+	}{
+		{"user", http.StatusOK, `{"ok": true, "error": null, "user": {"dn": "uid=test", "attributes": {"cn": "test", "memberOf": [], "expiresAt": null}}}`, true},
+		{"user (memberOf string)", http.StatusOK, `{"ok": true, "error": null, "user": {"dn": "uid=test", "attributes": {"memberOf": "cn=group"}}}`, false},
+		{"ldap error", http.StatusBadRequest, `{"ok": false, "error": {"code": 32, "result": "No Such Object", "message": "no such object"}, "requestID": "id"}`, true},
+		{"ldap error (code string)", http.StatusBadRequest, `{"ok": false, "error": {"code": "32"}}`, false},
+		{"undescribed status", http.StatusTeapot, `{}`, false},
+	}
+	for _, response := range responses {
+		err := op.ValidateResponse(response.status, "application/json; charset=utf-8", []byte(response.body))
+		AssertEquals(t, "ValidateResponse("+response.label+") -> valid", err == nil, response.valid)
+	}
+	AssertError(t, "ValidateResponse(text)", op.ValidateResponse(http.StatusOK, "text/plain", []byte("ok")), nil)
+
+	validated := gin.New()
+	validated.Use(app.OpenAPIMiddleware(document, true, true))
+	validated.POST("/ticket", func(c *gin.Context) { c.Status(http.StatusOK) })
+	validated.GET("/reports/expiring-users", func(c *gin.Context) { c.Status(http.StatusOK) })
+	validated.POST("/groups/:groupid/members/:userid", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		label  string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"POST /ticket", "POST", "/ticket", "username=test&password=test", http.StatusOK},
+		{"POST /ticket (missing password)", "POST", "/ticket", "username=test", http.StatusBadRequest},
+		{"POST /ticket (duplicate username)", "POST", "/ticket", "username=a&username=b&password=test", http.StatusBadRequest},
+		{"GET /reports/expiring-users", "GET", "/reports/expiring-users?days=7", "", http.StatusOK},
+		{"GET /reports/expiring-users (negative days)", "GET", "/reports/expiring-users?days=-1", "", http.StatusBadRequest},
+		{"GET /reports/expiring-users (invalid days)", "GET", "/reports/expiring-users?days=abc", "", http.StatusBadRequest},
+		{"POST member", "POST", "/groups/test/members/test", "expiresAt=2030-01-01T00:00:00Z", http.StatusOK},
+		{"POST member (invalid expiresAt)", "POST", "/groups/test/members/test", "expiresAt=tomorrow", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		var body io.Reader
+		if test.body != "" {
+			body = strings.NewReader(test.body)
+		}
+		request := httptest.NewRequest(test.method, test.target, body)
+		if test.body != "" {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		recorder := httptest.NewRecorder()
+		validated.ServeHTTP(recorder, request)
+		AssertStatus(t, test.label, recorder.Code, test.status)
+	}
+}
+
+func TestClient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/ticket", func(c *gin.Context) {
+		if c.PostForm("password") != "secret" {
+			c.JSON(http.StatusUnauthorized, gin.H{"auth": false})
+			return
+		}
+		c.SetCookie("PAASLDAPAuthTicket", "ticket", 0, "/", "", false, true)
+		c.JSON(http.StatusOK, gin.H{"auth": true})
+	})
+	authenticated := func(c *gin.Context) {
+		if _, err := c.Cookie("PAASLDAPAuthTicket"); err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"auth": false})
+		}
+	}
+	router.GET("/users/:userid", authenticated, func(c *gin.Context) {
+		if c.Param("userid") != "alice" {
+			c.JSON(http.StatusBadRequest, app.HandleResponse(gin.H{"ok": false, "error": ldap.NewError(ldap.LDAPResultNoSuchObject, errors.New("no such object"))}))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true, "error": nil, "user": gin.H{"dn": "uid=alice,ou=people,dc=test", "attributes": gin.H{"cn": "Alice", "uid": "alice", "memberOf": []string{"admins"}}}})
+	})
+	router.GET("/groups/:groupid", authenticated, func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true, "error": nil, "group": gin.H{"dn": "cn=admins,ou=groups,dc=test", "attributes": gin.H{"cn": "admins", "member": []string{"uid=alice,ou=people,dc=test"}, "labeledURI": []string{"https://example.com"}}}})
+	})
+	var form string
+	router.POST("/groups/:groupid/members/:userid", authenticated, func(c *gin.Context) {
+		_ = c.Request.ParseForm()
+		form = c.Request.PostForm.Encode()
+		c.JSON(http.StatusOK, gin.H{"ok": true, "error": nil})
+	})
+	router.POST("/groups/:groupid", authenticated, func(c *gin.Context) {
+		_ = c.Request.ParseForm()
+		form = c.Request.PostForm.Encode()
+		c.JSON(http.StatusOK, gin.H{"ok": true, "error": nil})
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx := context.Background()
+	paas, err := client.New(server.URL)
+	AssertError(t, "New()", err, nil)
+
+	// requests without a session are unauthorized
+	_, err = paas.GetUser(ctx, "alice")
+	AssertEquals(t, "IsUnauthorized(err)", client.IsUnauthorized(err), true)
+	err = paas.Login(ctx, "alice", "wrong")
+	AssertEquals(t, "IsUnauthorized(err)", client.IsUnauthorized(err), true)
+
+	err = paas.Login(ctx, "alice", "secret")
+	AssertError(t, "Login()", err, nil)
+	AssertEquals(t, "len(Cookies())", len(paas.Cookies()), 1)
+
+	user, err := paas.GetUser(ctx, "alice")
+	AssertError(t, "GetUser()", err, nil)
+	AssertEquals(t, "user.Attributes.CN", user.Attributes.CN, "Alice")
+	AssertEquals(t, "user.Attributes.MemberOf", strings.Join(user.Attributes.MemberOf, ","), "admins")
+
+	_, err = paas.GetUser(ctx, "bob")
+	AssertEquals(t, "IsErrorWithCode(err, NoSuchObject)", client.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject), true)
+	AssertEquals(t, "IsUnauthorized(err)", client.IsUnauthorized(err), false)
+
+	group, err := paas.GetGroup(ctx, "admins")
+	AssertError(t, "GetGroup()", err, nil)
+	AssertEquals(t, "group.Attributes.Member", strings.Join(group.Attributes.Member, ","), "uid=alice,ou=people,dc=test")
+	AssertEquals(t, "group.Attributes.Extra[labeledURI]", strings.Join(group.Attributes.Extra["labeledURI"], ","), "https://example.com")
+
+	// the cookies of one client can be restored in another
+	restored, err := client.New(server.URL)
+	AssertError(t, "New()", err, nil)
+	restored.SetCookies(paas.Cookies())
+	err = restored.AddGroupMember(ctx, "admins", "alice", time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC))
+	AssertError(t, "AddGroupMember()", err, nil)
+	AssertEquals(t, "form", form, "expiresAt=2030-01-02T03%3A04%3A05Z")
+
+	// cleared attributes are sent with an empty value
+	err = paas.UpdateGroup(ctx, "admins", client.GroupFields{Description: "admins", Clear: []string{"owner", "seeAlso"}})
+	AssertError(t, "UpdateGroup()", err, nil)
+	AssertEquals(t, "form", form, "description=admins&owner=&seeAlso=")
+}
+
+func TestCtl(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/ticket", func(c *gin.Context) {
+		c.SetCookie("PAASLDAPAuthTicket", c.PostForm("username"), 0, "/", "", false, true)
+		c.JSON(http.StatusOK, gin.H{"auth": true})
+	})
+	authenticated := func(c *gin.Context) {
+		if _, err := c.Cookie("PAASLDAPAuthTicket"); err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"auth": false})
+		}
+	}
+	router.GET("/users", authenticated, func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true, "error": nil, "users": []gin.H{
+			{"dn": "uid=alice,ou=people,dc=test", "attributes": gin.H{"uid": "alice", "cn": "Alice", "sn": "A", "mail": "alice@test", "memberOf": []string{"admins"}}},
+		}})
+	})
+	created := []string{}
+	router.POST("/users/:userid", authenticated, func(c *gin.Context) {
+		if c.Param("userid") == "exists" {
+			c.JSON(http.StatusBadRequest, app.HandleResponse(gin.H{"ok": false, "error": ldap.NewError(ldap.LDAPResultEntryAlreadyExists, errors.New("already exists"))}))
+			return
+		}
+		created = append(created, c.Param("userid")+":"+c.PostForm("cn"))
+		c.JSON(http.StatusOK, gin.H{"ok": true, "error": nil})
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	credentials := filepath.Join(t.TempDir(), "credentials.json")
+	run := func(stdin string, args ...string) (int, string, string) {
+		var stdout, stderr strings.Builder
+		code := ctl.Run(append([]string{"--credentials", credentials}, args...), strings.NewReader(stdin), &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	code, _, stderr := run("", "--url", server.URL, "users", "list")
+	AssertEquals(t, "users list before login", code, 1)
+	AssertEquals(t, "not logged in", strings.Contains(stderr, "not logged in"), true)
+
+	code, _, _ = run("secret\n", "--url", server.URL, "login", "--username", "admin", "--password-stdin")
+	AssertEquals(t, "login", code, 0)
+
+	// the url and ticket are read from the credentials file
+	code, stdout, _ := run("", "users", "list")
+	AssertEquals(t, "users list", code, 0)
+	AssertEquals(t, "users list table", strings.Contains(stdout, "alice  Alice  A   alice@test  admins"), true)
+
+	code, stdout, _ = run("", "--output", "json", "users", "list")
+	AssertEquals(t, "users list json", code, 0)
+	var users []client.User
+	AssertError(t, "json.Unmarshal()", json.Unmarshal([]byte(stdout), &users), nil)
+	AssertEquals(t, "users[0].Attributes.UID", users[0].Attributes.UID, "alice")
+
+	code, stdout, _ = run("", "--output", "yaml", "users", "list")
+	AssertEquals(t, "users list yaml", code, 0)
+	AssertEquals(t, "users list yaml uid", strings.Contains(stdout, "uid: alice"), true)
+
+	// flags may follow the positional id
+	code, _, _ = run("", "users", "create", "bob", "--cn", "Bob", "--sn", "B", "--mail", "bob@test", "--password", "secret")
+	AssertEquals(t, "users create", code, 0)
+
+	// bulk create continues after a failure and exits with an error
+	code, stdout, stderr = run(`{"uid": "carol", "cn": "Carol"} {"uid": "exists", "cn": "Exists"} {"uid": "dave", "cn": "Dave"}`, "users", "create", "-")
+	AssertEquals(t, "users create -", code, 1)
+	AssertEquals(t, "created", strings.Join(created, ","), "bob:Bob,carol:Carol,dave:Dave")
+	AssertEquals(t, "stdout", stdout, "created user carol\ncreated user dave\n")
+	AssertEquals(t, "stderr", strings.Contains(stderr, "exists: LDAP Result Code 68"), true)
+
+	code, _, _ = run("", "users", "rename", "bob")
+	AssertEquals(t, "unknown command", code, 2)
+}
+
+func TestSessionStore(t *testing.T) {
+	dir := t.TempDir()
+	secretKey, err := app.LoadSecretKey(filepath.Join(dir, "sessions.key"))
+	AssertError(t, "LoadSecretKey()", err, nil)
+	AssertEquals(t, "len(secretKey)", len(secretKey), 256)
+	reloaded, err := app.LoadSecretKey(filepath.Join(dir, "sessions.key"))
+	AssertError(t, "LoadSecretKey()", err, nil)
+	AssertEquals(t, "reloaded secret key", string(reloaded), string(secretKey))
+
+	path := filepath.Join(dir, "sessions.json")
+	store, err := app.NewSessionStore(path)
+	AssertError(t, "NewSessionStore()", err, nil)
+	expiresAt := time.Now().Add(time.Hour)
+	AssertError(t, "Add()", store.Add("uuid-1", "alice", expiresAt), nil)
+	AssertError(t, "Add()", store.Add("uuid-2", "bob", expiresAt), nil)
+	AssertError(t, "Delete()", store.Delete("uuid-2"), nil)
+
+	// only the metadata of each session is stored
+	content, err := os.ReadFile(path)
+	AssertError(t, "ReadFile()", err, nil)
+	AssertEquals(t, "contains password", strings.Contains(strings.ToLower(string(content)), "password"), false)
+
+	store, err = app.NewSessionStore(path)
+	AssertError(t, "NewSessionStore()", err, nil)
+	sessions := store.List()
+	AssertEquals(t, "len(List())", len(sessions), 1)
+	AssertEquals(t, "sessions[0].UUID", sessions[0].UUID, "uuid-1")
+	AssertEquals(t, "sessions[0].Username", sessions[0].Username, "alice")
+	AssertEquals(t, "sessions[0].ExpiresAt", sessions[0].ExpiresAt.Equal(expiresAt), true)
+
+	// sessions which have expired or were persisted without an expiry are deleted instead of restored
+	config, err := app.GetConfig("test_config.json")
+	AssertError(t, "GetConfig()", err, nil)
+	config.SessionStore.MaxAge = 3600
+	AssertEquals(t, "PersistedSessionMaxAge()", config.PersistedSessionMaxAge(), time.Hour)
+	config.SessionStore.MaxAge = 0
+	config.SessionCookie.MaxAge = 60
+	AssertEquals(t, "PersistedSessionMaxAge() from sessionCookie.maxAge", config.PersistedSessionMaxAge(), time.Minute)
+	config.SessionCookie.MaxAge = 0
+	AssertEquals(t, "PersistedSessionMaxAge() default", config.PersistedSessionMaxAge(), 24*time.Hour)
+	expiredPath := filepath.Join(dir, "expired.json")
+	created := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	content = []byte(`[{"uuid":"uuid-3","username":"alice","createdAt":"` + created + `"},{"uuid":"uuid-4","username":"alice","createdAt":"` + created + `","expiresAt":"` + time.Now().Add(-time.Minute).UTC().Format(time.RFC3339) + `"}]`)
+	AssertError(t, "WriteFile()", os.WriteFile(expiredPath, content, 0600), nil)
+	expired, err := app.NewSessionStore(expiredPath)
+	AssertError(t, "NewSessionStore()", err, nil)
+	AssertEquals(t, "RestoreSessions()", app.RestoreSessions(config, expired), 0)
+	AssertEquals(t, "len(List()) after RestoreSessions()", len(expired.List()), 0)
+
+	// closing the sessions unregisters every session
+	app.LDAPSessions = make(map[string]*app.LDAPClient)
+	app.SetSession("uuid-1", &app.LDAPClient{})
+	app.SetSession("uuid-2", &app.LDAPClient{})
+	AssertEquals(t, "CloseSessions()", app.CloseSessions(), 2)
+	AssertEquals(t, "SessionCount()", app.SessionCount(), 0)
+}
+
+func TestShutdownServer(t *testing.T) {
+	app.LDAPSessions = make(map[string]*app.LDAPClient)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		fmt.Fprint(w, "done")
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done() // streams end when the server shuts down
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	AssertError(t, "Listen()", err, nil)
+	server := app.NewServer(listener.Addr().String(), mux)
+	go server.Serve(listener)
+	url := "http://" + listener.Addr().String()
+
+	stream, err := http.Get(url + "/stream")
+	AssertError(t, "GET /stream", err, nil)
+	defer stream.Body.Close()
+
+	body := make(chan string)
+	go func() {
+		response, err := http.Get(url + "/slow")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer response.Body.Close()
+		content, _ := io.ReadAll(response.Body)
+		body <- string(content)
+	}()
+	<-started
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- app.ShutdownServer(server, 5*time.Second)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	AssertEquals(t, "in-flight request", <-body, "done")
+	AssertError(t, "ShutdownServer()", <-shutdown, nil)
+	_, err = http.Get(url + "/slow")
+	AssertEquals(t, "requests after shutdown fail", err != nil, true)
+
+	// a request which does not finish before the timeout is closed
+	server = app.NewServer("127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	listener, err = net.Listen("tcp", "127.0.0.1:0")
+	AssertError(t, "Listen()", err, nil)
+	go server.Serve(listener)
+	go http.Get("http://" + listener.Addr().String())
+	time.Sleep(50 * time.Millisecond)
+	err = app.ShutdownServer(server, 10*time.Millisecond)
+	AssertEquals(t, "ShutdownServer() timeout", errors.Is(err, context.DeadlineExceeded), true)
+}
+
+func TestConfig_Formats(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.yaml": "listenPort: 8082\nldapURL: ldap://localhost\nbaseDN: dc=test,dc=paasldap\ngroupAttributes: [seeAlso]\nsessionCookieName: PAASLDAPAuthTicket\nsessionCookie:\n  maxAge: 7200\n",
+		"config.toml": "listenPort = 8082\nldapURL = \"ldap://localhost\"\nbaseDN = \"dc=test,dc=paasldap\"\ngroupAttributes = [\"seeAlso\"]\nsessionCookieName = \"PAASLDAPAuthTicket\"\n\n[sessionCookie]\nmaxAge = 7200\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		AssertError(t, "WriteFile()", os.WriteFile(path, []byte(content), 0600), nil)
+		config, err := app.GetConfig(path)
+		AssertError(t, name, err, nil)
+		AssertEquals(t, name+" listenPort", config.ListenPort, 8082)
+		AssertEquals(t, name+" baseDN", config.BaseDN, "dc=test,dc=paasldap")
+		AssertEquals(t, name+" groupAttributes", strings.Join(config.GroupAttributes, ","), "seeAlso")
+		AssertEquals(t, name+" sessionCookie.maxAge", config.SessionCookie.MaxAge, 7200)
+		AssertError(t, name+" Validate()", config.Validate(), nil)
+	}
+
+	// misspelled fields are rejected
+	path := filepath.Join(dir, "unknown.json")
+	AssertError(t, "WriteFile()", os.WriteFile(path, []byte(`{"listenPort": 80, "ldapUrl": "ldap://localhost", "baseDNs": "dc=test"}`), 0600), nil)
+	_, err := app.GetConfig(path)
+	AssertError(t, "GetConfig()", err, fmt.Errorf(`json: unknown field "baseDNs"`))
+
+	// the deprecated audit.adminGroup is moved to adminGroup
+	path = filepath.Join(dir, "deprecated.json")
+	AssertError(t, "WriteFile()", os.WriteFile(path, []byte(`{"listenPort": 80, "ldapURL": "ldap://localhost", "baseDN": "dc=test", "audit": {"adminGroup": "admins"}}`), 0600), nil)
+	config, err := app.GetConfig(path)
+	AssertError(t, "GetConfig()", err, nil)
+	AssertEquals(t, "config.AdminGroup", config.AdminGroup, "admins")
+	AssertEquals(t, "config.Audit.AdminGroup", config.Audit.AdminGroup, "")
+
+	AssertError(t, "WriteFile()", os.WriteFile(path, []byte(`{"adminGroup": "operators", "audit": {"adminGroup": "admins"}}`), 0600), nil)
+	_, err = app.GetConfig(path)
+	AssertError(t, "GetConfig()", err, errors.New("audit.adminGroup: is deprecated and conflicts with adminGroup"))
+}
+
+func TestConfig_EnvOverrides(t *testing.T) {
+	AssertEquals(t, "EnvName(ldapURL)", app.EnvName("ldapURL"), "PAASLDAP_LDAP_URL")
+	AssertEquals(t, "EnvName(serviceAccount.bindDN)", app.EnvName("serviceAccount.bindDN"), "PAASLDAP_SERVICE_ACCOUNT_BIND_DN")
+	AssertEquals(t, "EnvName(sessionCookie.httpOnly)", app.EnvName("sessionCookie.httpOnly"), "PAASLDAP_SESSION_COOKIE_HTTP_ONLY")
+
+	t.Setenv("PAASLDAP_LISTEN_PORT", "8443")
+	t.Setenv("PAASLDAP_SERVICE_ACCOUNT_PASSWORD", "secret")
+	t.Setenv("PAASLDAP_SESSION_COOKIE_SECURE", "true")
+	t.Setenv("PAASLDAP_GROUP_ATTRIBUTES", "seeAlso, labeledURI")
+	t.Setenv("PAASLDAP_TRACING_HEADERS", "Authorization=Bearer token")
+	t.Setenv("PAASLDAP_WEBHOOKS_SUBSCRIBERS", `[{"url": "https://example.com/hook", "secret": "s"}]`)
+	config, err := app.GetConfig("test_config.json")
+	AssertError(t, "GetConfig()", err, nil)
+	AssertEquals(t, "config.ListenPort", config.ListenPort, 8443)
+	AssertEquals(t, "config.ServiceAccount.Password", config.ServiceAccount.Password, "secret")
+	AssertEquals(t, "config.SessionCookie.Secure", config.SessionCookie.Secure, true)
+	AssertEquals(t, "config.GroupAttributes", strings.Join(config.GroupAttributes, ","), "seeAlso,labeledURI")
+	AssertEquals(t, "config.Tracing.Headers", config.Tracing.Headers["Authorization"], "Bearer token")
+	AssertEquals(t, "config.Webhooks.Subscribers[0].URL", config.Webhooks.Subscribers[0].URL, "https://example.com/hook")
+
+	t.Setenv("PAASLDAP_LISTEN_PORT", "http")
+	_, err = app.GetConfig("test_config.json")
+	AssertError(t, "GetConfig()", err, fmt.Errorf("PAASLDAP_LISTEN_PORT: must be an integer"))
+}
+
+func TestConfig_Validate(t *testing.T) {
+	config, err := app.GetConfig("test_config.json")
+	AssertError(t, "GetConfig()", err, nil)
+	AssertError(t, "Validate()", config.Validate(), nil)
+
+	config.ListenPort = 0
+	config.LdapURL = "localhost"
+	config.BaseDN = ""
+	config.Log.Format = "xml"
+	config.AccountExpiry.Policy = "lock"
+	config.Webhooks.Subscribers = []app.WebhookSubscriber{{URL: "ftp://example.com"}}
+	config.GroupAttributes = []string{"seeAlso", "Member"}
+	err = config.Validate()
+	expected := []string{
+		"listenPort: must be between 1 and 65535, got 0",
+		`ldapURL: must be a url, got "localhost"`,
+		"baseDN: is required",
+		`groupAttributes[1]: "Member" is managed by the API and cannot be an extra attribute`,
+		`log.format: must be one of ["" "json" "text"], got "xml"`,
+		"serviceAccount: bindDN and password are required by accountExpiry.policy, softDelete, membershipExpiry, and sessionStore",
+		"accountExpiry.attribute: is required when accountExpiry.policy is set",
+		`webhooks.subscribers[0].url: scheme must be one of ["http" "https"], got "ftp"`,
+	}
+	AssertError(t, "Validate()", err, errors.New(strings.Join(expected, "\n")))
+}
+
+func TestConfigReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(content string) {
+		AssertError(t, "WriteFile()", os.WriteFile(path, []byte(content), 0600), nil)
+	}
+	write(`{"listenPort": 80, "ldapURL": "ldap://localhost", "baseDN": "dc=test", "sessionCookieName": "PAASLDAPAuthTicket", "sessionCookie": {"maxAge": 7200}}`)
+	config, err := app.GetConfig(path)
+	AssertError(t, "GetConfig()", err, nil)
+	reloader := app.NewConfigReloader(path, config)
+	successes := testutil.ToFloat64(app.ConfigReloads.WithLabelValues("success"))
+	failures := testutil.ToFloat64(app.ConfigReloads.WithLabelValues("failure"))
+
+	// live fields are applied and fields read at startup keep the running value
+	write(`{"listenPort": 8080, "ldapURL": "ldaps://ldap.test", "baseDN": "dc=test", "groupAttributes": ["seeAlso"], "sessionCookieName": "PAASLDAPAuthTicket", "sessionCookie": {"maxAge": 60}}`)
+	status, err := reloader.Reload()
+	AssertError(t, "Reload()", err, nil)
+	AssertEquals(t, "status.Reloads", status.Reloads, 1)
+	AssertEquals(t, "status.Applied", strings.Join(status.Applied, ","), "groupAttributes,sessionCookie.maxAge")
+	AssertEquals(t, "status.NewSessionsOnly", strings.Join(status.NewSessionsOnly, ","), "ldapURL")
+	AssertEquals(t, "status.PendingRestart", strings.Join(status.PendingRestart, ","), "listenPort")
+	AssertEquals(t, "Config().LdapURL", reloader.Config().LdapURL, "ldaps://ldap.test")
+	AssertEquals(t, "Config().SessionCookie.MaxAge", reloader.Config().SessionCookie.MaxAge, 60)
+	AssertEquals(t, "Config().ListenPort", reloader.Config().ListenPort, 80)
+
+	// an invalid file is reported and the active config is kept
+	write(`{"listenPort": 8080, "ldapURL": "ldaps://ldap.test", "sessionCookieName": "PAASLDAPAuthTicket"}`)
+	status, err = reloader.Reload()
+	AssertError(t, "Reload()", err, errors.New("baseDN: is required"))
+	AssertEquals(t, "status.Failures", status.Failures, 1)
+	AssertEquals(t, "status.LastError", status.LastError, "baseDN: is required")
+	AssertEquals(t, "status.PendingRestart", strings.Join(status.PendingRestart, ","), "listenPort")
+	AssertEquals(t, "Config().BaseDN", reloader.Config().BaseDN, "dc=test")
+
+	AssertEquals(t, "ConfigReloads success", testutil.ToFloat64(app.ConfigReloads.WithLabelValues("success")), successes+1)
+	AssertEquals(t, "ConfigReloads failure", testutil.ToFloat64(app.ConfigReloads.WithLabelValues("failure")), failures+1)
+}
+
+// write a self signed certificate and key for the host to the files
+func writeCertificate(t *testing.T, certFile string, keyFile string, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	AssertError(t, "GenerateKey()", err, nil)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	AssertError(t, "CreateCertificate()", err, nil)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	AssertError(t, "MarshalECPrivateKey()", err, nil)
+	AssertError(t, "WriteFile()", os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600), nil)
+	AssertError(t, "WriteFile()", os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600), nil)
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCertificate(t, certFile, keyFile, "first")
+
+	certificates, err := app.NewCertificateReloader(certFile, keyFile)
+	AssertError(t, "NewCertificateReloader()", err, nil)
+	config, err := app.GetConfig("test_config.json")
+	AssertError(t, "GetConfig()", err, nil)
+	config.TLS.MinVersion = "1.2"
+	config.TLS.CipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}
+	tlsConfig, err := app.NewTLSConfig(config, certificates)
+	AssertError(t, "NewTLSConfig()", err, nil)
+
+	server := app.NewServer("127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}))
+	server.TLSConfig = tlsConfig
+	server.Protocols = app.NewProtocols(config)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	AssertError(t, "Listen()", err, nil)
+	go server.ServeTLS(listener, "", "")
+	defer server.Close()
+
+	// returns the protocol of the response and the common name of the server certificate
+	get := func(maxVersion uint16) (string, string, error) {
+		transport := &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true, MaxVersion: maxVersion},
+			ForceAttemptHTTP2: true,
+		}
+		defer transport.CloseIdleConnections()
+		response, err := (&http.Client{Transport: transport}).Get("https://" + listener.Addr().String())
+		if err != nil {
+			return "", "", err
+		}
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		return string(body), response.TLS.PeerCertificates[0].Subject.CommonName, nil
+	}
+	proto, commonName, err := get(0)
+	AssertError(t, "GET", err, nil)
+	AssertEquals(t, "proto", proto, "HTTP/2.0")
+	AssertEquals(t, "commonName", commonName, "first")
+
+	// the certificate is replaced when the files change without restarting the server
+	reloaded, err := certificates.Reload()
+	AssertError(t, "Reload()", err, nil)
+	AssertEquals(t, "reloaded unchanged files", reloaded, false)
+	writeCertificate(t, certFile, keyFile, "second")
+	future := time.Now().Add(time.Minute)
+	AssertError(t, "Chtimes()", os.Chtimes(keyFile, future, future), nil)
+	reloaded, err = certificates.Reload()
+	AssertError(t, "Reload()", err, nil)
+	AssertEquals(t, "reloaded changed files", reloaded, true)
+	_, commonName, err = get(tls.VersionTLS12)
+	AssertError(t, "GET", err, nil)
+	AssertEquals(t, "commonName", commonName, "second")
+
+	config.TLS.MinVersion = "1.3"
+	tlsConfig, err = app.NewTLSConfig(config, certificates)
+	AssertError(t, "NewTLSConfig()", err, nil)
+	AssertEquals(t, "tlsConfig.MinVersion", tlsConfig.MinVersion, tls.VersionTLS13)
+
+	config.TLS.CipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+	_, err = app.NewTLSConfig(config, certificates)
+	AssertError(t, "NewTLSConfig()", err, errors.New("unknown or insecure cipher suite TLS_RSA_WITH_RC4_128_SHA"))
+
+	redirect := app.RedirectHandler(8443)
+	recorder := httptest.NewRecorder()
+	redirect.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://ldap.test:8080/users?x=1", nil))
+	AssertStatus(t, "GET redirect", recorder.Code, http.StatusMovedPermanently)
+	AssertEquals(t, "Location", recorder.Header().Get("Location"), "https://ldap.test:8443/users?x=1")
+	recorder = httptest.NewRecorder()
+	app.RedirectHandler(443).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "http://ldap.test/ticket", nil))
+	AssertStatus(t, "POST redirect", recorder.Code, http.StatusPermanentRedirect)
+	AssertEquals(t, "Location", recorder.Header().Get("Location"), "https://ldap.test/ticket")
+
+	// the redirect uses the port of the TCP listener which is not listenPort when socket activated
+	unixListener, err := net.Listen("unix", filepath.Join(t.TempDir(), "api.sock"))
+	AssertError(t, "Listen(unix)", err, nil)
+	defer unixListener.Close()
+	AssertEquals(t, "TCPListenerPort(unix)", app.TCPListenerPort([]net.Listener{unixListener}, 8082), 8082)
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	AssertError(t, "Listen(tcp)", err, nil)
+	defer tcpListener.Close()
+	AssertEquals(t, "TCPListenerPort(unix, tcp)", app.TCPListenerPort([]net.Listener{unixListener, tcpListener}, 8082), tcpListener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestClientCertificate(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "pve", Organization: []string{"Automation"}},
+		DNSNames:       []string{"node1.pve.domain.net"},
+		EmailAddresses: []string{"bad,uid@domain.net", "backup@automation.domain.net"},
+	}
+
+	mappings := []app.ClientCertMapping{
+		{Field: "san.email", Match: `(.+)@automation\.domain\.net`},
+		{Field: "san.dns", Match: `(?P<node>[a-z0-9]+)\.pve\.domain\.net`, UID: "pve-${node}"},
+		{Field: "subject.cn"},
+	}
+	uid, ok := app.MapClientCertificate(cert, mappings)
+	AssertEquals(t, "MapClientCertificate() ok", ok, true)
+	AssertEquals(t, "MapClientCertificate() uid", uid, "backup")
+	uid, _ = app.MapClientCertificate(cert, mappings[1:])
+	AssertEquals(t, "MapClientCertificate() uid", uid, "pve-node1")
+	uid, _ = app.MapClientCertificate(cert, mappings[2:])
+	AssertEquals(t, "MapClientCertificate() uid", uid, "pve")
+	_, ok = app.MapClientCertificate(cert, []app.ClientCertMapping{{Field: "san.email", Match: `(.+)@domain\.net`}}) // match must cover the whole value and uids cannot contain DN special characters
+	AssertEquals(t, "MapClientCertificate() ok", ok, false)
+	_, ok = app.MapClientCertificate(cert, []app.ClientCertMapping{{Field: "subject.cn", Match: "pv"}})
+	AssertEquals(t, "MapClientCertificate() ok", ok, false)
+
+	control := app.NewControlProxiedAuthorization("dn:uid=pve,ou=people,dc=domain,dc=net").(*ldap.ControlString)
+	AssertEquals(t, "ControlType", control.GetControlType(), app.ControlTypeProxiedAuthorization)
+	AssertEquals(t, "Criticality", control.Criticality, true)
+	AssertEquals(t, "ControlValue", control.ControlValue, "dn:uid=pve,ou=people,dc=domain,dc=net")
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	writeCertificate(t, caFile, filepath.Join(dir, "ca.key"), "ca")
+	config, err := app.GetConfig("test_config.json")
+	AssertError(t, "GetConfig()", err, nil)
+	config.TLS.CertFile = caFile
+	config.TLS.KeyFile = filepath.Join(dir, "ca.key")
+	config.TLS.ClientAuth.CAFile = caFile
+	config.TLS.ClientAuth.Required = true
+	config.TLS.ClientAuth.Mappings = mappings
+	certificates, err := app.NewCertificateReloader(config.TLS.CertFile, config.TLS.KeyFile)
+	AssertError(t, "NewCertificateReloader()", err, nil)
+	tlsConfig, err := app.NewTLSConfig(config, certificates)
+	AssertError(t, "NewTLSConfig()", err, nil)
+	AssertEquals(t, "ClientAuth", tlsConfig.ClientAuth, tls.RequireAndVerifyClientCert)
+
+	config.TLS.ClientAuth.Mappings = []app.ClientCertMapping{{Field: "subject.ou", Match: "("}}
+	err = config.Validate()
+	AssertEquals(t, "Validate() field", strings.Contains(fmt.Sprint(err), "tls.clientAuth.mappings[0].field"), true)
+	AssertEquals(t, "Validate() match", strings.Contains(fmt.Sprint(err), "tls.clientAuth.mappings[0].match"), true)
+
+	config.TLS.ClientAuth.Mappings = []app.ClientCertMapping{{Field: "san.uri"}}
+	router := gin.New()
+	router.Use(sessions.Sessions("test", cookie.NewStore([]byte(RandString(32)))))
+	router.Use(app.ClientCertMiddleware(config))
+	router.GET("/", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"session": sessions.Default(c).Get("SessionUUID")})
+	})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil)) // without a certificate the request is passed through unauthenticated
+	AssertStatus(t, "Status", recorder.Code, http.StatusOK)
+	AssertEquals(t, "Body", recorder.Body.String(), `{"session":null}`)
+	AssertEquals(t, "Set-Cookie", recorder.Header().Get("Set-Cookie"), "")
+
+	recorder = httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}} // the certificate has no uri to map
+	router.ServeHTTP(recorder, request)
+	AssertStatus(t, "Status", recorder.Code, http.StatusUnauthorized)
+}
+
+func TestSystemd(t *testing.T) {
+	dir := t.TempDir()
+
+	notifySocket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: notifySocket, Net: "unixgram"})
+	AssertError(t, "ListenUnixgram()", err, nil)
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", notifySocket)
+	sent, err := app.SdNotify("READY=1")
+	AssertError(t, "SdNotify()", err, nil)
+	AssertEquals(t, "SdNotify() sent", sent, true)
+	message := make([]byte, 64)
+	n, err := conn.Read(message)
+	AssertError(t, "Read()", err, nil)
+	AssertEquals(t, "notification", string(message[:n]), "READY=1")
+	t.Setenv("NOTIFY_SOCKET", "")
+	sent, err = app.SdNotify("READY=1") // not supervised by systemd
+	AssertError(t, "SdNotify()", err, nil)
+	AssertEquals(t, "SdNotify() sent", sent, false)
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", fmt.Sprint(os.Getpid()))
+	AssertEquals(t, "WatchdogInterval()", app.WatchdogInterval(), 30*time.Second)
+	t.Setenv("WATCHDOG_PID", fmt.Sprint(os.Getpid()+1)) // the watchdog is meant for another process
+	AssertEquals(t, "WatchdogInterval()", app.WatchdogInterval(), time.Duration(0))
+
+	// the watchdog is only notified while requests are served
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	status := http.StatusOK
+	block := make(chan struct{})
+	router.GET("/version", func(c *gin.Context) {
+		if status == 0 {
+			<-block
+		}
+		c.JSON(status, gin.H{})
+	})
+	check := app.HandlerHealthCheck(router.Handler())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	AssertError(t, "HandlerHealthCheck()", check(ctx), nil)
+	cancel()
+	status = http.StatusInternalServerError
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	AssertError(t, "HandlerHealthCheck() 500", check(ctx), errors.New("health check responded with status 500"))
+	cancel()
+	status = 0
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	AssertError(t, "HandlerHealthCheck() blocked", check(ctx), errors.New("health check did not complete before the deadline"))
+	cancel()
+	close(block)
+
+	t.Setenv("LISTEN_PID", fmt.Sprint(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := app.SystemdListeners()
+	AssertError(t, "SystemdListeners()", err, nil)
+	AssertEquals(t, "SystemdListeners()", len(listeners), 0)
+	_, ok := os.LookupEnv("LISTEN_FDS")
+	AssertEquals(t, "LISTEN_FDS is unset", ok, false)
+
+	socket := filepath.Join(dir, "api.sock")
+	AssertError(t, "WriteFile()", os.WriteFile(socket, nil, 0600), nil)
+	_, err = app.ListenUnix(socket, 0660, "") // refuses to remove a file which is not a socket
+	AssertEquals(t, "ListenUnix() error", err != nil, true)
+	AssertError(t, "Remove()", os.Remove(socket), nil)
+
+	listener, err := app.ListenUnix(socket, 0660, "")
+	AssertError(t, "ListenUnix()", err, nil)
+	info, err := os.Stat(socket)
+	AssertError(t, "Stat()", err, nil)
+	AssertEquals(t, "socket mode", info.Mode().Perm(), os.FileMode(0660))
+	server := app.NewServer("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	go server.Serve(listener)
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	response, err := httpClient.Get("http://localhost/")
+	AssertError(t, "Get()", err, nil)
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	AssertEquals(t, "Body", string(body), "ok")
+	server.Close()
+	_, err = os.Stat(socket) // the socket is removed when the listener is closed
+	AssertEquals(t, "socket removed", errors.Is(err, os.ErrNotExist), true)
+}
+
+func TestCORSAndCSRF(t *testing.T) {
+	config, err := app.GetConfig("test_config.json")
+	AssertError(t, "GetConfig()", err, nil)
+	config.CORS.AllowedOrigins = []string{"https://paas.domain.net"}
+	config.CORS.AllowCredentials = true
+	config.CORS.MaxAge = 600
+	config.CSRF.Enabled = true
+
+	config.CORS.AllowedOrigins = []string{"*"}
+	AssertEquals(t, "Validate() wildcard with credentials", strings.Contains(fmt.Sprint(config.Validate()), "cors.allowedOrigins"), true)
+	config.CORS.AllowedOrigins = []string{"https://paas.domain.net/app"}
+	AssertEquals(t, "Validate() origin with path", strings.Contains(fmt.Sprint(config.Validate()), "cors.allowedOrigins[0]"), true)
+	config.CORS.AllowedOrigins = []string{"https://paas.domain.net"}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(app.CORSMiddleware(config))
+	router.Use(sessions.Sessions("PAASLDAPAuthTicket", cookie.NewStore([]byte(RandString(32)))))
+	router.Use(app.CSRFMiddleware(config))
+	router.POST("/ticket", func(c *gin.Context) { // issues the token the same way as the API
+		token := app.NewCSRFToken()
+		session := sessions.Default(c)
+		session.Set("SessionUUID", "uuid")
+		session.Set("CSRFToken", token)
+		session.Save()
+		c.Header(app.CSRFHeader, token)
+		c.JSON(http.StatusOK, gin.H{"auth": true, "csrfToken": token})
+	})
+	router.GET("/users/:userid", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true, "error": nil, "user": gin.H{"dn": "uid=alice,ou=people,dc=test"}})
+	})
+	router.DELETE("/users/:userid", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true, "error": nil})
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	preflight := func(origin string) *http.Response {
+		request, _ := http.NewRequest(http.MethodOptions, server.URL+"/users/alice", nil)
+		request.Header.Set("Origin", origin)
+		request.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+		response, err := http.DefaultClient.Do(request)
+		AssertError(t, "Do()", err, nil)
+		response.Body.Close()
+		return response
+	}
+	response := preflight("https://paas.domain.net")
+	AssertStatus(t, "preflight", response.StatusCode, http.StatusNoContent)
+	AssertEquals(t, "Access-Control-Allow-Origin", response.Header.Get("Access-Control-Allow-Origin"), "https://paas.domain.net")
+	AssertEquals(t, "Access-Control-Allow-Credentials", response.Header.Get("Access-Control-Allow-Credentials"), "true")
+	AssertEquals(t, "Access-Control-Allow-Methods", response.Header.Get("Access-Control-Allow-Methods"), "GET, POST, PUT, DELETE")
+	AssertEquals(t, "Access-Control-Max-Age", response.Header.Get("Access-Control-Max-Age"), "600")
+	response = preflight("https://evil.example.com")
+	AssertStatus(t, "preflight", response.StatusCode, http.StatusForbidden)
+	AssertEquals(t, "Access-Control-Allow-Origin", response.Header.Get("Access-Control-Allow-Origin"), "")
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL+"/users/alice", nil)
+	request.Header.Set("Origin", "https://paas.domain.net")
+	response, err = http.DefaultClient.Do(request)
+	AssertError(t, "Do()", err, nil)
+	response.Body.Close()
+	AssertEquals(t, "Access-Control-Expose-Headers", response.Header.Get("Access-Control-Expose-Headers"), "X-CSRF-Token, X-Request-ID")
+
+	ctx := context.Background()
+	paas, err := client.New(server.URL)
+	AssertError(t, "New()", err, nil)
+	AssertError(t, "Login()", paas.Login(ctx, "alice", "secret"), nil)
+	AssertEquals(t, "CSRFToken() issued", paas.CSRFToken() != "", true)
+	AssertError(t, "DeleteUser() with token", paas.DeleteUser(ctx, "bob"), nil)
+
+	token := paas.CSRFToken()
+	paas.SetCSRFToken("")
+	_, err = paas.GetUser(ctx, "alice") // safe methods do not need the token
+	AssertError(t, "GetUser() without token", err, nil)
+	err = paas.DeleteUser(ctx, "bob")
+	var apiErr *client.Error
+	AssertEquals(t, "DeleteUser() without token", errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden, true)
+	paas.SetCSRFToken(token + "x")
+	err = paas.DeleteUser(ctx, "bob")
+	AssertEquals(t, "DeleteUser() with wrong token", errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden, true)
+
+	anonymous, err := client.New(server.URL) // requests without a ticket are left to the handlers
+	AssertError(t, "New()", err, nil)
+	AssertError(t, "DeleteUser() without ticket", anonymous.DeleteUser(ctx, "bob"), nil)
+
+	router = gin.New()
+	router.Use(sessions.Sessions("PAASLDAPAuthTicket", cookie.NewStore([]byte(RandString(32)))))
+	router.Use(func(c *gin.Context) { // sets the session the same way as ClientCertMiddleware
+		sessions.Default(c).Set("SessionUUID", "mtls:pve")
+		c.Next()
+	})
+	router.Use(app.CSRFMiddleware(config))
+	router.DELETE("/users/:userid", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true, "error": nil})
+	})
+	certRequest := func(headers map[string]string) int {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodDelete, "/users/bob", nil)
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	AssertStatus(t, "client certificate without browser headers", certRequest(nil), http.StatusOK)
+	AssertStatus(t, "client certificate from another origin", certRequest(map[string]string{"Origin": "https://evil.example.com"}), http.StatusForbidden)
+	AssertStatus(t, "client certificate from another site", certRequest(map[string]string{"Sec-Fetch-Site": "cross-site"}), http.StatusForbidden)
+	AssertStatus(t, "client certificate from an allowed origin", certRequest(map[string]string{"Origin": "https://paas.domain.net", "Sec-Fetch-Site": "same-site"}), http.StatusOK)
+	AssertStatus(t, "client certificate from the same origin", certRequest(map[string]string{"Origin": "https://api.domain.net", "Sec-Fetch-Site": "same-origin"}), http.StatusOK)
+}
+
+func TestSessions(t *testing.T) {
+	app.LDAPSessions = make(map[string]*app.LDAPClient)
+	first := &app.LDAPClient{}
+	second := &app.LDAPClient{}
+	third := &app.LDAPClient{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ { // concurrent requests must not corrupt the sessions
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			uuid := fmt.Sprint(i)
+			app.SetSession(uuid, first)
+			app.GetSession(uuid)
+			app.SessionCount()
+			app.RemoveSession(uuid)
+		}()
+	}
+	wg.Wait()
+	AssertEquals(t, "SessionCount()", app.SessionCount(), 0)
+
+	AssertEquals(t, "SwapSession() when absent", app.SwapSession("mtls:alice", nil, first) == first, true)
+	AssertEquals(t, "SwapSession() when unchanged", app.SwapSession("mtls:alice", first, second) == second, true)
+	AssertEquals(t, "SwapSession() when replaced concurrently", app.SwapSession("mtls:alice", first, third) == second, true)
+	AssertEquals(t, "GetSession()", app.GetSession("mtls:alice") == second, true)
+	AssertEquals(t, "RemoveSession()", app.RemoveSession("mtls:alice") == second, true)
+	AssertEquals(t, "GetSession() after RemoveSession()", app.GetSession("mtls:alice") == nil, true)
+}